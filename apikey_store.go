@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeyStore persists API keys. Only the SHA-256 hash of a key's secret is
+// stored; the plaintext is shown once, when the key is issued.
+type APIKeyStore interface {
+	// Create stores a new key under hash.
+	Create(ctx context.Context, key APIKey, hash string) error
+
+	// Lookup returns the key stored under hash, or errAPIKeyNotFound.
+	// Revoked keys are returned too; callers check RevokedAt.
+	Lookup(ctx context.Context, hash string) (APIKey, error)
+
+	// List returns every key, revoked ones included, oldest first.
+	List(ctx context.Context) ([]APIKey, error)
+
+	// Revoke marks the key with id as revoked at t. Revoking a revoked key
+	// keeps its original time; an unknown id is errAPIKeyNotFound.
+	Revoke(ctx context.Context, id string, t time.Time) error
+
+	// Touch records that the key with id was used at t.
+	Touch(ctx context.Context, id string, t time.Time) error
+}
+
+var errAPIKeyNotFound = errors.New("api key not found")
+
+// apiKeyStoreFor returns the APIKeyStore kept alongside a sightings store, so
+// keys live in the same database as the data they grant access to.
+func apiKeyStoreFor(store SightingStore) (APIKeyStore, error) {
+	switch s := store.(type) {
+	case *postgresStore:
+		return newSQLAPIKeyStore(s.db, pgPlaceholder), nil
+	case *partitionedStore:
+		return newSQLAPIKeyStore(s.db, pgPlaceholder), nil
+	case *sqliteStore:
+		return newSQLAPIKeyStore(s.db, sqlitePlaceholder), nil
+	case *memoryStore:
+		return newMemoryAPIKeyStore(), nil
+	default:
+		return nil, fmt.Errorf("no API key store for %T", store)
+	}
+}
+
+const apiKeyColumns = `"id", "name", "scopes", "roles", "created_at", "last_used_at", "revoked_at"`
+
+// sqlAPIKeyStore keeps API keys in the "api_keys" table created by the
+// 0002_create_api_keys migration. Scopes and roles are stored
+// comma-separated so Postgres and SQLite share the same queries.
+type sqlAPIKeyStore struct {
+	db          *sql.DB
+	placeholder func(int) string
+}
+
+func newSQLAPIKeyStore(db *sql.DB, placeholder func(int) string) *sqlAPIKeyStore {
+	return &sqlAPIKeyStore{db: db, placeholder: placeholder}
+}
+
+// bind replaces the %s verbs of query with the store's placeholders.
+func (s *sqlAPIKeyStore) bind(query string, n int) string {
+	marks := make([]any, n)
+	for i := range marks {
+		marks[i] = s.placeholder(i + 1)
+	}
+	return fmt.Sprintf(query, marks...)
+}
+
+func (s *sqlAPIKeyStore) Create(ctx context.Context, key APIKey, hash string) error {
+	query := s.bind(`INSERT INTO "api_keys" ("id", "name", "key_hash", "scopes", "roles", "created_at") VALUES (%s, %s, %s, %s, %s, %s)`, 6)
+	_, err := s.db.ExecContext(ctx, query, key.ID, key.Name, hash, strings.Join(key.Scopes, ","), strings.Join(key.Roles, ","), key.CreatedAt.UTC())
+	if err != nil {
+		return newStoreError(stageQuery, "api_keys", err)
+	}
+	return nil
+}
+
+func (s *sqlAPIKeyStore) Lookup(ctx context.Context, hash string) (APIKey, error) {
+	query := s.bind(`SELECT `+apiKeyColumns+` FROM "api_keys" WHERE "key_hash" = %s`, 1)
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, query, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, errAPIKeyNotFound
+	}
+	if err != nil {
+		return APIKey{}, newStoreError(stageScan, "api_keys", err)
+	}
+	return key, nil
+}
+
+func (s *sqlAPIKeyStore) List(ctx context.Context) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM "api_keys" ORDER BY "created_at", "id"`)
+	if err != nil {
+		return nil, newStoreError(stageQuery, "api_keys", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, newStoreError(stageScan, "api_keys", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newStoreError(stageIterate, "api_keys", err)
+	}
+	return keys, nil
+}
+
+func (s *sqlAPIKeyStore) Revoke(ctx context.Context, id string, t time.Time) error {
+	query := s.bind(`UPDATE "api_keys" SET "revoked_at" = COALESCE("revoked_at", %s) WHERE "id" = %s`, 2)
+	res, err := s.db.ExecContext(ctx, query, t.UTC(), id)
+	if err != nil {
+		return newStoreError(stageQuery, "api_keys", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errAPIKeyNotFound
+	}
+	return nil
+}
+
+func (s *sqlAPIKeyStore) Touch(ctx context.Context, id string, t time.Time) error {
+	query := s.bind(`UPDATE "api_keys" SET "last_used_at" = %s WHERE "id" = %s`, 2)
+	if _, err := s.db.ExecContext(ctx, query, t.UTC(), id); err != nil {
+		return newStoreError(stageQuery, "api_keys", err)
+	}
+	return nil
+}
+
+// rowScanner is the Scan method shared by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var scopes, roles string
+	var lastUsed, revoked sql.NullTime
+	if err := row.Scan(&key.ID, &key.Name, &scopes, &roles, &key.CreatedAt, &lastUsed, &revoked); err != nil {
+		return APIKey{}, err
+	}
+	key.Scopes = splitList(scopes)
+	key.Roles = splitList(roles)
+	if lastUsed.Valid {
+		key.LastUsedAt = &lastUsed.Time
+	}
+	if revoked.Valid {
+		key.RevokedAt = &revoked.Time
+	}
+	return key, nil
+}
+
+// splitList parses a comma-separated column, never returning nil.
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// memoryAPIKeyStore keeps keys for the memory store; they are lost on restart.
+type memoryAPIKeyStore struct {
+	mu     sync.Mutex
+	byHash map[string]*APIKey
+}
+
+func newMemoryAPIKeyStore() *memoryAPIKeyStore {
+	return &memoryAPIKeyStore{byHash: make(map[string]*APIKey)}
+}
+
+func (s *memoryAPIKeyStore) Create(_ context.Context, key APIKey, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byHash[hash] = &key
+	return nil
+}
+
+func (s *memoryAPIKeyStore) Lookup(_ context.Context, hash string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.byHash[hash]
+	if !ok {
+		return APIKey{}, errAPIKeyNotFound
+	}
+	return *key, nil
+}
+
+func (s *memoryAPIKeyStore) List(context.Context) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]APIKey, 0, len(s.byHash))
+	for _, key := range s.byHash {
+		keys = append(keys, *key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+func (s *memoryAPIKeyStore) Revoke(_ context.Context, id string, t time.Time) error {
+	return s.update(id, func(key *APIKey) {
+		if key.RevokedAt == nil {
+			key.RevokedAt = &t
+		}
+	})
+}
+
+func (s *memoryAPIKeyStore) Touch(_ context.Context, id string, t time.Time) error {
+	return s.update(id, func(key *APIKey) { key.LastUsedAt = &t })
+}
+
+func (s *memoryAPIKeyStore) update(id string, fn func(*APIKey)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range s.byHash {
+		if key.ID == id {
+			fn(key)
+			return nil
+		}
+	}
+	return errAPIKeyNotFound
+}
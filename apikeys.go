@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// API key scopes. Read covers GET requests, write everything else.
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+)
+
+// apiKeyPrefix marks API keys so they are recognizable in configs and logs.
+const apiKeyPrefix = "mbk_"
+
+// touchInterval limits how often a key's last-used time is written, so busy
+// clients do not cost a database write per request.
+const touchInterval = time.Minute
+
+// APIKey is an issued key as stored and listed; the secret itself is never
+// kept.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Roles      []string   `json:"roles"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+// generateAPIKey returns a new random key and its storage hash.
+func generateAPIKey() (key, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return key, hashAPIKey(key), nil
+}
+
+// hashAPIKey returns the hash a key is stored under. Keys carry 256 random
+// bits, so a plain SHA-256 is enough to make a leaked table useless.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAPIKeyID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+var errAPIKeyRevoked = errors.New("api key was revoked")
+
+// apiKeyAuthenticator resolves X-API-Key headers into sessions.
+type apiKeyAuthenticator struct {
+	store APIKeyStore
+}
+
+// Authenticate returns the session of an active key, errAPIKeyNotFound or
+// errAPIKeyRevoked. The user ID of a key session is "apikey:<id>".
+func (a *apiKeyAuthenticator) Authenticate(ctx context.Context, key string) (Session, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return Session{}, errAPIKeyNotFound
+	}
+	stored, err := a.store.Lookup(ctx, hashAPIKey(key))
+	if err != nil {
+		return Session{}, err
+	}
+	if stored.RevokedAt != nil {
+		return Session{}, fmt.Errorf("key %s: %w", stored.ID, errAPIKeyRevoked)
+	}
+
+	now := time.Now().UTC()
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) >= touchInterval {
+		if err := a.store.Touch(ctx, stored.ID, now); err != nil {
+			slog.WarnContext(ctx, "failed to record API key use", "key_id", stored.ID, "error", err)
+		}
+	}
+	return Session{UserID: "apikey:" + stored.ID, Roles: stored.Roles, Scopes: stored.Scopes}, nil
+}
+
+// requireScope rejects sessions whose scopes do not cover the request
+// method: GET, HEAD and OPTIONS need read, anything else write. Bearer
+// sessions carry no scopes and are not restricted.
+func requireScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := scopeWrite
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			scope = scopeRead
+		}
+		session, _ := sessionFrom(r.Context())
+		if session.Scopes != nil && !contains(session.Scopes, scope) {
+			writeProblem(w, r, http.StatusForbidden, codeForbidden, fmt.Sprintf("API key lacks the %s scope", scope))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireRole rejects callers that do not hold role.
+func requireRole(role string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session, _ := sessionFrom(r.Context())
+			if !contains(session.Roles, role) {
+				writeProblem(w, r, http.StatusForbidden, codeForbidden, role+" role required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// createAPIKeyRequest is the body of POST /api/admin/keys.
+type createAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Roles  []string `json:"roles"`
+}
+
+// validate checks the request and fills in the default read scope.
+func (req *createAPIKeyRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{scopeRead}
+	}
+	for _, scope := range req.Scopes {
+		if scope != scopeRead && scope != scopeWrite {
+			return fmt.Errorf("unknown scope %q (want read or write)", scope)
+		}
+	}
+	if req.Roles == nil {
+		req.Roles = []string{}
+	}
+	for _, role := range req.Roles {
+		if _, ok := rolePolicies[role]; !ok {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+	return nil
+}
+
+// createdAPIKey is the response to issuing a key: the stored key plus its
+// plaintext, which is not shown again.
+type createdAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// createAPIKey issues a new key.
+func (s *server) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidBody, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidBody, err.Error())
+		return
+	}
+
+	id, err := newAPIKeyID()
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, codeInternal, "Failed to generate API key")
+		return
+	}
+	plaintext, hash, err := generateAPIKey()
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, codeInternal, "Failed to generate API key")
+		return
+	}
+	key := APIKey{ID: id, Name: req.Name, Scopes: req.Scopes, Roles: req.Roles, CreatedAt: time.Now().UTC()}
+	if err := s.keys.Create(r.Context(), key, hash); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	session, _ := sessionFrom(r.Context())
+	slog.InfoContext(r.Context(), "API key issued", "key_id", key.ID, "key_name", key.Name, "user_id", session.UserID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdAPIKey{APIKey: key, Key: plaintext})
+}
+
+// listAPIKeys lists every issued key without its secret.
+func (s *server) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.keys.List(r.Context())
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if keys == nil {
+		keys = []APIKey{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// revokeAPIKey revokes the key named in the route. The key stays listed.
+func (s *server) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := s.keys.Revoke(r.Context(), id, time.Now().UTC())
+	if errors.Is(err, errAPIKeyNotFound) {
+		writeProblem(w, r, http.StatusNotFound, codeNotFound, fmt.Sprintf("No API key with id %q", id))
+		return
+	}
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+
+	session, _ := sessionFrom(r.Context())
+	slog.InfoContext(r.Context(), "API key revoked", "key_id", id, "user_id", session.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/descope/go-sdk/descope/client"
+	"github.com/descope/go-sdk/descope/sdk"
+	"github.com/gorilla/mux"
+)
+
+// contextKey is the type of the request context keys set by the auth
+// middleware, so they cannot collide with keys from other packages.
+type contextKey string
+
+const (
+	contextKeyUserID contextKey = "userID"
+	contextKeyRoles  contextKey = "roles"
+	contextKeyScopes contextKey = "scopes"
+)
+
+// Session is the identity a validated token or API key resolves to. Scopes
+// is only set for API keys; nil means the session is not restricted.
+type Session struct {
+	UserID string   `json:"userId"`
+	Roles  []string `json:"roles"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// errInvalidToken is returned by validators for tokens that are expired,
+// malformed or unknown.
+var errInvalidToken = errors.New("invalid session token")
+
+// TokenValidator turns a bearer token into a Session. The Descope client is
+// the production implementation; staticTokenValidator stands in for it
+// offline and in tests.
+type TokenValidator interface {
+	Validate(ctx context.Context, token string) (Session, error)
+}
+
+// descopeValidator validates Descope session JWTs. Roles are matched against
+// the names in roles, the ones the API knows how to treat.
+type descopeValidator struct {
+	auth  sdk.Authentication
+	roles []string
+}
+
+// newDescopeValidator creates a Descope client for projectID.
+func newDescopeValidator(projectID string, roles []string) (*descopeValidator, error) {
+	c, err := client.NewWithConfig(&client.Config{ProjectID: projectID})
+	if err != nil {
+		return nil, err
+	}
+	return &descopeValidator{auth: c.Auth, roles: roles}, nil
+}
+
+func (v *descopeValidator) Validate(ctx context.Context, token string) (Session, error) {
+	authorized, t, err := v.auth.ValidateSessionWithToken(ctx, token)
+	if err != nil {
+		return Session{}, fmt.Errorf("%w: %v", errInvalidToken, err)
+	}
+	if !authorized || t == nil {
+		return Session{}, errInvalidToken
+	}
+	return Session{UserID: t.ID, Roles: v.auth.GetMatchedRoles(ctx, t, v.roles)}, nil
+}
+
+// staticTokenValidator resolves tokens from a fixed table, read from the
+// JSON file named by AUTH_STATIC_TOKENS ({"token": {"userId", "roles"}}).
+type staticTokenValidator map[string]Session
+
+func loadStaticTokens(path string) (staticTokenValidator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read static tokens: %w", err)
+	}
+	var tokens staticTokenValidator
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("parse static tokens %s: %w", path, err)
+	}
+	return tokens, nil
+}
+
+func (v staticTokenValidator) Validate(_ context.Context, token string) (Session, error) {
+	session, ok := v[token]
+	if !ok {
+		return Session{}, errInvalidToken
+	}
+	return session, nil
+}
+
+// newTokenValidator picks the validator for bearer tokens: Descope when a
+// project ID is configured, otherwise the static tokens file. It returns nil
+// when neither is configured, leaving API keys as the only way in.
+func newTokenValidator(cfg authConfig) (TokenValidator, error) {
+	if cfg.DescopeProjectID != "" {
+		return newDescopeValidator(cfg.DescopeProjectID, cfg.DescopeRoles)
+	}
+	if cfg.StaticTokens != "" {
+		return loadStaticTokens(cfg.StaticTokens)
+	}
+	return nil, nil
+}
+
+// sessionValidationMiddleware validates the X-API-Key header or Bearer token
+// of requests that carry one and stores the caller's session in the request
+// context. Requests without credentials pass through as anonymous; invalid
+// credentials are rejected. v may be nil when only API keys are accepted,
+// and keys nil when API keys are disabled.
+func sessionValidationMiddleware(v TokenValidator, keys *apiKeyAuthenticator) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+				if keys == nil {
+					writeProblem(w, r, http.StatusUnauthorized, codeInvalidCredentials, "API keys are not accepted by this server")
+					return
+				}
+				session, err := keys.Authenticate(r.Context(), apiKey)
+				if err != nil && !errors.Is(err, errAPIKeyNotFound) && !errors.Is(err, errAPIKeyRevoked) {
+					writeStoreError(w, r, err)
+					return
+				}
+				if err != nil {
+					slog.WarnContext(r.Context(), "API key rejected", "error", err)
+					writeProblem(w, r, http.StatusUnauthorized, codeInvalidCredentials, "Invalid API key")
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(withSession(r.Context(), session)))
+				return
+			}
+
+			if r.Header.Get("Authorization") == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			sessionToken, ok := bearerToken(r)
+			if !ok {
+				writeProblem(w, r, http.StatusUnauthorized, codeInvalidCredentials, "Authorization must be a Bearer token")
+				return
+			}
+			if v == nil {
+				writeProblem(w, r, http.StatusUnauthorized, codeInvalidCredentials, "Session tokens are not accepted by this server")
+				return
+			}
+
+			session, err := v.Validate(r.Context(), sessionToken)
+			if err != nil {
+				slog.WarnContext(r.Context(), "session validation failed", "error", err)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				writeProblem(w, r, http.StatusUnauthorized, codeInvalidCredentials, "Invalid session token")
+				return
+			}
+			if session.UserID == "" {
+				writeProblem(w, r, http.StatusUnauthorized, codeInvalidCredentials, "User ID not found in token")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(withSession(r.Context(), session)))
+		})
+	}
+}
+
+// requireSession rejects anonymous requests. It runs after
+// sessionValidationMiddleware.
+func requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := sessionFrom(r.Context()); !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "No session token or API key provided")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken extracts the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// withSession returns ctx carrying the session's user ID, roles and scopes.
+func withSession(ctx context.Context, session Session) context.Context {
+	ctx = context.WithValue(ctx, contextKeyUserID, session.UserID)
+	ctx = context.WithValue(ctx, contextKeyRoles, session.Roles)
+	return context.WithValue(ctx, contextKeyScopes, session.Scopes)
+}
+
+// sessionFrom returns the session stored by sessionValidationMiddleware.
+// ok is false for anonymous requests.
+func sessionFrom(ctx context.Context) (session Session, ok bool) {
+	session.UserID, ok = ctx.Value(contextKeyUserID).(string)
+	session.Roles, _ = ctx.Value(contextKeyRoles).([]string)
+	session.Scopes, _ = ctx.Value(contextKeyScopes).([]string)
+	return session, ok && session.UserID != ""
+}
+
+// getSession reports the caller's user ID, roles and scopes, so clients can
+// check what their token or key grants.
+func getSession(w http.ResponseWriter, r *http.Request) {
+	session, _ := sessionFrom(r.Context())
+	if session.Roles == nil {
+		session.Roles = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// authRouter wires the auth middleware as main does, in front of
+// /api/session and the admin key listing.
+func authRouter(t *testing.T) (*mux.Router, APIKeyStore) {
+	t.Helper()
+	tokens, err := loadStaticTokens("testdata/tokens.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := newMemoryAPIKeyStore()
+	srv := &server{store: newMemoryStore(nil), keys: keys}
+
+	router := mux.NewRouter()
+	router.Use(sessionValidationMiddleware(tokens, &apiKeyAuthenticator{store: keys}))
+	router.Use(requireScope)
+	protected := router.PathPrefix("/api").Subrouter()
+	protected.Use(requireSession)
+	protected.HandleFunc("/session", getSession).Methods("GET")
+	admin := protected.PathPrefix("/admin").Subrouter()
+	admin.Use(requireRole(adminRole))
+	admin.HandleFunc("/keys", srv.listAPIKeys).Methods("GET")
+	return router, keys
+}
+
+func TestAuthentication(t *testing.T) {
+	router, keys := authRouter(t)
+	ctx := context.Background()
+
+	// Keys are stored under their hash only. A row holding the plain key
+	// instead must not match it.
+	active, revoked, plain := apiKeyPrefix+"active", apiKeyPrefix+"revoked", apiKeyPrefix+"plain"
+	keys.Create(ctx, APIKey{ID: "k1", Scopes: []string{scopeRead}, Roles: []string{adminRole}}, hashAPIKey(active))
+	keys.Create(ctx, APIKey{ID: "k2", Scopes: []string{scopeRead}}, hashAPIKey(revoked))
+	keys.Create(ctx, APIKey{ID: "k3", Scopes: []string{scopeRead}}, plain)
+	if err := keys.Revoke(ctx, "k2", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		value  string
+		status int
+		user   string
+		roles  []string
+	}{
+		{"no credentials", "/api/session", "", "", http.StatusUnauthorized, "", nil},
+		{"bad bearer token", "/api/session", "Authorization", "Bearer not-a-token", http.StatusUnauthorized, "", nil},
+		{"not a bearer token", "/api/session", "Authorization", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, "", nil},
+		{"valid token", "/api/session", "Authorization", "Bearer dev-viewer-token", http.StatusOK, "dev-viewer", []string{}},
+		{"valid token with a role", "/api/session", "Authorization", "Bearer dev-admin-token", http.StatusOK, "dev-admin", []string{adminRole}},
+		{"valid token with the wrong role", "/api/admin/keys", "Authorization", "Bearer dev-viewer-token", http.StatusForbidden, "", nil},
+		{"valid token with the admin role", "/api/admin/keys", "Authorization", "Bearer dev-admin-token", http.StatusOK, "", nil},
+		{"API key found by its hash", "/api/session", "X-API-Key", active, http.StatusOK, "apikey:k1", []string{adminRole}},
+		{"API key with the admin role", "/api/admin/keys", "X-API-Key", active, http.StatusOK, "", nil},
+		{"API key stored unhashed", "/api/session", "X-API-Key", plain, http.StatusUnauthorized, "", nil},
+		{"unknown API key", "/api/session", "X-API-Key", apiKeyPrefix + "unknown", http.StatusUnauthorized, "", nil},
+		{"revoked API key", "/api/session", "X-API-Key", revoked, http.StatusUnauthorized, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, r)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.user == "" {
+				return
+			}
+			var session Session
+			if err := json.NewDecoder(rec.Body).Decode(&session); err != nil {
+				t.Fatal(err)
+			}
+			if session.UserID != tt.user || !slices.Equal(session.Roles, tt.roles) {
+				t.Errorf("session = %+v, want user %q with roles %v", session, tt.user, tt.roles)
+			}
+		})
+	}
+}
+
+// downAPIKeyStore fails every lookup as an unreachable database would.
+type downAPIKeyStore struct {
+	APIKeyStore
+}
+
+func (downAPIKeyStore) Lookup(context.Context, string) (APIKey, error) {
+	return APIKey{}, newStoreError(stageQuery, "api_keys", driver.ErrBadConn)
+}
+
+func TestAuthenticationStoreUnavailable(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(sessionValidationMiddleware(nil, &apiKeyAuthenticator{store: downAPIKeyStore{newMemoryAPIKeyStore()}}))
+	router.HandleFunc("/api/session", getSession).Methods("GET")
+
+	r := httptest.NewRequest("GET", "/api/session", nil)
+	r.Header.Set("X-API-Key", apiKeyPrefix+"active")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusServiceUnavailable, rec.Body)
+	}
+	var problem struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Code != codeDBUnavailable {
+		t.Errorf("code = %q, want %q", problem.Code, codeDBUnavailable)
+	}
+}
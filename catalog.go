@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of table reported by the catalog.
+const (
+	tableKindDaily  = "daily"
+	tableKindLegacy = "legacy"
+)
+
+// TableInfo describes one table of sightings known to the store.
+type TableInfo struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// Date is the day a daily table holds, as YYYY-MM-DD.
+	Date      string `json:"date,omitempty"`
+	RowCount  int64  `json:"rowCount"`
+	FirstDate string `json:"firstDate,omitempty"`
+	LastDate  string `json:"lastDate,omitempty"`
+}
+
+// defaultDailyTableFormat reproduces the original daily table names, e.g.
+// june212025 or june12025.
+const defaultDailyTableFormat = "{month}{d}{yyyy}"
+
+// tableNaming builds and parses daily table names from a format such as
+// "{month}{d}{yyyy}". The tokens are {month} (june), {mon} (jun), {mm} (06),
+// {m} (6), {dd} (05), {d} (5) and {yyyy}; anything else is literal.
+type tableNaming struct {
+	format  string
+	pattern *regexp.Regexp
+	tokens  []string
+}
+
+// tableNameTokens maps each format token to the pattern it parses.
+var tableNameTokens = map[string]string{
+	"{month}": `(january|february|march|april|may|june|july|august|september|october|november|december)`,
+	"{mon}":   `(jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)`,
+	"{mm}":    `(\d{2})`,
+	"{m}":     `(\d{1,2})`,
+	"{dd}":    `(\d{2})`,
+	"{d}":     `(\d{1,2})`,
+	"{yyyy}":  `(\d{4})`,
+}
+
+var tableNameTokenPattern = regexp.MustCompile(`\{[a-z]+\}`)
+
+// newTableNaming compiles format. It must name the year, the month and the
+// day exactly once each.
+func newTableNaming(format string) (tableNaming, error) {
+	n := tableNaming{format: format}
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	last := 0
+	for _, loc := range tableNameTokenPattern.FindAllStringIndex(format, -1) {
+		token := format[loc[0]:loc[1]]
+		expr, ok := tableNameTokens[token]
+		if !ok {
+			return tableNaming{}, fmt.Errorf("table name format %q: unknown token %s", format, token)
+		}
+		pattern.WriteString(regexp.QuoteMeta(format[last:loc[0]]))
+		pattern.WriteString(expr)
+		n.tokens = append(n.tokens, token)
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(format[last:]))
+	pattern.WriteString("$")
+
+	counts := map[string]int{}
+	for _, token := range n.tokens {
+		switch token {
+		case "{month}", "{mon}", "{mm}", "{m}":
+			counts["month"]++
+		case "{dd}", "{d}":
+			counts["day"]++
+		case "{yyyy}":
+			counts["year"]++
+		}
+	}
+	for _, part := range []string{"year", "month", "day"} {
+		if counts[part] != 1 {
+			return tableNaming{}, fmt.Errorf("table name format %q must contain exactly one %s token", format, part)
+		}
+	}
+
+	n.pattern = regexp.MustCompile(pattern.String())
+	return n, nil
+}
+
+// mustTableNaming is newTableNaming for formats known to be valid.
+func mustTableNaming(format string) tableNaming {
+	n, err := newTableNaming(format)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// defaultTableNaming names tables as the original schema does.
+var defaultTableNaming = mustTableNaming(defaultDailyTableFormat)
+
+// name returns the daily table holding day's sightings.
+func (n tableNaming) name(day calendarDate) string {
+	month := strings.ToLower(day.Month.String())
+	return strings.NewReplacer(
+		"{month}", month,
+		"{mon}", month[:3],
+		"{mm}", fmt.Sprintf("%02d", int(day.Month)),
+		"{m}", strconv.Itoa(int(day.Month)),
+		"{dd}", fmt.Sprintf("%02d", day.Day),
+		"{d}", strconv.Itoa(day.Day),
+		"{yyyy}", fmt.Sprintf("%04d", day.Year),
+	).Replace(n.format)
+}
+
+// parse returns the day held by a daily table, or false when name does not
+// follow the format or names an impossible date.
+func (n tableNaming) parse(name string) (time.Time, bool) {
+	m := n.pattern.FindStringSubmatch(name)
+	if m == nil {
+		return time.Time{}, false
+	}
+
+	var year, day int
+	var month time.Month
+	for i, token := range n.tokens {
+		v := m[i+1]
+		switch token {
+		case "{month}", "{mon}":
+			for mo := time.January; mo <= time.December; mo++ {
+				if strings.HasPrefix(strings.ToLower(mo.String()), v) {
+					month = mo
+					break
+				}
+			}
+		case "{mm}", "{m}":
+			mo, _ := strconv.Atoi(v)
+			month = time.Month(mo)
+		case "{dd}", "{d}":
+			day, _ = strconv.Atoi(v)
+		case "{yyyy}":
+			year, _ = strconv.Atoi(v)
+		}
+	}
+
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if date.Day() != day || date.Month() != month || date.Year() != year {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// tableCatalog caches the tables the store reports so handlers can tell
+// which days have data without querying for them.
+type tableCatalog struct {
+	store SightingStore
+
+	mu          sync.RWMutex
+	tables      []TableInfo
+	byDate      map[string]TableInfo
+	refreshedAt time.Time
+}
+
+func newTableCatalog(store SightingStore) *tableCatalog {
+	return &tableCatalog{store: store}
+}
+
+// Refresh reloads the table list from the store.
+func (c *tableCatalog) Refresh(ctx context.Context) error {
+	tables, err := c.store.Tables(ctx)
+	if err != nil {
+		return err
+	}
+
+	byDate := make(map[string]TableInfo)
+	for _, t := range tables {
+		if t.Kind == tableKindDaily {
+			byDate[t.Date] = t
+		}
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].Kind != tables[j].Kind {
+			return tables[i].Kind == tableKindDaily
+		}
+		if tables[i].Date != tables[j].Date {
+			return tables[i].Date < tables[j].Date
+		}
+		return tables[i].Name < tables[j].Name
+	})
+
+	c.mu.Lock()
+	c.tables = tables
+	c.byDate = byDate
+	c.refreshedAt = time.Now()
+	c.mu.Unlock()
+
+	slog.InfoContext(ctx, "catalog refreshed", "daily_tables", len(byDate), "tables", len(tables))
+	return nil
+}
+
+// Run refreshes the catalog every interval until ctx is done. Failed
+// refreshes keep the previous snapshot.
+func (c *tableCatalog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Refresh(ctx); err != nil {
+				slog.ErrorContext(ctx, "catalog refresh failed", "error", err)
+			}
+		}
+	}
+}
+
+// RefreshedAt returns when the catalog last refreshed successfully, or the
+// zero time if it never has.
+func (c *tableCatalog) RefreshedAt() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.refreshedAt
+}
+
+// HasDate reports whether the catalog knows of data for day. Before the
+// first refresh it optimistically answers true so the store gets asked.
+func (c *tableCatalog) HasDate(day calendarDate) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.refreshedAt.IsZero() {
+		return true
+	}
+	_, ok := c.byDate[day.String()]
+	return ok
+}
+
+// catalogResponse is the body of /catalog.
+type catalogResponse struct {
+	RefreshedAt  *time.Time  `json:"refreshedAt"`
+	FirstDate    string      `json:"firstDate,omitempty"`
+	LastDate     string      `json:"lastDate,omitempty"`
+	DaysCovered  int         `json:"daysCovered"`
+	TotalRows    int64       `json:"totalRows"`
+	DailyTables  []TableInfo `json:"dailyTables"`
+	LegacyTables []TableInfo `json:"legacyTables"`
+}
+
+// snapshot summarizes the current catalog contents.
+func (c *tableCatalog) snapshot() catalogResponse {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	resp := catalogResponse{
+		DailyTables:  []TableInfo{},
+		LegacyTables: []TableInfo{},
+	}
+	if !c.refreshedAt.IsZero() {
+		refreshedAt := c.refreshedAt
+		resp.RefreshedAt = &refreshedAt
+	}
+
+	for _, t := range c.tables {
+		resp.TotalRows += t.RowCount
+		if t.Kind != tableKindDaily {
+			resp.LegacyTables = append(resp.LegacyTables, t)
+			continue
+		}
+		resp.DailyTables = append(resp.DailyTables, t)
+		resp.DaysCovered++
+		if resp.FirstDate == "" || t.Date < resp.FirstDate {
+			resp.FirstDate = t.Date
+		}
+		if t.Date > resp.LastDate {
+			resp.LastDate = t.Date
+		}
+	}
+	return resp
+}
+
+// getCatalog lists the daily and legacy tables with their row counts and
+// date coverage.
+func (s *server) getCatalog(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.catalog.snapshot())
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete server configuration. It is read from the YAML file
+// named by -config or CONFIG_FILE, then overridden by environment variables,
+// and validated before anything is opened.
+type Config struct {
+	Server    serverConfig    `yaml:"server"`
+	CORS      corsConfig      `yaml:"cors"`
+	Store     storeConfig     `yaml:"store"`
+	Database  dbConfig        `yaml:"database"`
+	Tables    tablesConfig    `yaml:"tables"`
+	Catalog   catalogConfig   `yaml:"catalog"`
+	Auth      authConfig      `yaml:"auth"`
+	RateLimit rateLimitConfig `yaml:"rateLimit"`
+	Features  featuresConfig  `yaml:"features"`
+	Log       logConfig       `yaml:"log"`
+}
+
+type serverConfig struct {
+	// Listen is the address to serve on, e.g. ":8080".
+	Listen string    `yaml:"listen"`
+	TLS    tlsConfig `yaml:"tls"`
+
+	// Timeouts guarding against slow clients; see http.Server. A streamed
+	// response gets a fresh WriteTimeout each time it flushes.
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
+	ReadTimeout       time.Duration `yaml:"readTimeout"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout"`
+	// ShutdownTimeout is how long in-flight requests may drain on SIGINT or
+	// SIGTERM before they are cancelled.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+// tlsConfig enables HTTPS when both files are set.
+type tlsConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+func (t tlsConfig) enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+type corsConfig struct {
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	AllowedMethods []string `yaml:"allowedMethods"`
+	AllowedHeaders []string `yaml:"allowedHeaders"`
+}
+
+type storeConfig struct {
+	// Kind is postgres, memory or sqlite.
+	Kind       string `yaml:"kind"`
+	Fixtures   string `yaml:"fixtures"`
+	SQLitePath string `yaml:"sqlitePath"`
+}
+
+type tablesConfig struct {
+	// DailyFormat names the per-day tables; see tableNaming for its tokens.
+	DailyFormat string `yaml:"dailyFormat"`
+	// Legacy is the table served by /monarchsjune2025.
+	Legacy string `yaml:"legacy"`
+	// Layout is how the postgres store reads sightings: daily, partitioned
+	// or auto.
+	Layout string `yaml:"layout"`
+}
+
+type catalogConfig struct {
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+}
+
+type authConfig struct {
+	DescopeProjectID string   `yaml:"descopeProjectID"`
+	DescopeRoles     []string `yaml:"descopeRoles"`
+	StaticTokens     string   `yaml:"staticTokens"`
+}
+
+type rateLimitConfig struct {
+	Light rateLimit `yaml:"light"`
+	Heavy rateLimit `yaml:"heavy"`
+	// APIKeyDailyRows is the daily row quota of each API key; 0 disables it.
+	APIKeyDailyRows int64 `yaml:"apiKeyDailyRows"`
+	// TrustedProxies lists the IPs or CIDR prefixes of the proxies in front
+	// of the server. Anonymous clients connecting through them are
+	// identified by the X-Forwarded-For hop the proxies appended.
+	TrustedProxies []string `yaml:"trustedProxies"`
+}
+
+// limit returns the limit of a route class.
+func (c rateLimitConfig) limit(class string) rateLimit {
+	if class == routeHeavy {
+		return c.Heavy
+	}
+	return c.Light
+}
+
+// featuresConfig switches optional parts of the API on and off.
+type featuresConfig struct {
+	// PublicRoutes serves the sightings routes without authentication, in
+	// addition to /api.
+	PublicRoutes bool `yaml:"publicRoutes"`
+	// APIKeys accepts X-API-Key and serves the key admin routes.
+	APIKeys bool `yaml:"apiKeys"`
+	// Streaming allows stream=true.
+	Streaming bool `yaml:"streaming"`
+	// Metrics serves Prometheus metrics on /metrics.
+	Metrics bool `yaml:"metrics"`
+}
+
+// defaultConfig matches the behavior of the server before it had a config
+// file.
+func defaultConfig() Config {
+	return Config{
+		Server: serverConfig{
+			Listen:            ":8080",
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      2 * time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		CORS: corsConfig{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Content-Type", "Authorization", "X-API-Key"},
+		},
+		Store: storeConfig{Kind: "postgres", SQLitePath: ":memory:"},
+		Database: dbConfig{
+			MaxOpenConns:     10,
+			MaxIdleConns:     5,
+			ConnMaxLifetime:  30 * time.Minute,
+			ConnMaxIdleTime:  5 * time.Minute,
+			QueryConcurrency: 4,
+		},
+		Tables:  tablesConfig{DailyFormat: defaultDailyTableFormat, Legacy: defaultLegacyTable, Layout: layoutAuto},
+		Catalog: catalogConfig{RefreshInterval: 10 * time.Minute},
+		Auth:    authConfig{DescopeRoles: []string{adminRole}},
+		RateLimit: rateLimitConfig{
+			Light:           rateLimit{PerMinute: 120, Burst: 30},
+			Heavy:           rateLimit{PerMinute: 12, Burst: 4},
+			APIKeyDailyRows: 1000000,
+		},
+		Features: featuresConfig{PublicRoutes: true, APIKeys: true, Streaming: true, Metrics: true},
+		Log:      logConfig{Level: "info", Format: "json"},
+	}
+}
+
+// loadConfig builds the configuration from the defaults, the YAML file at
+// path (skipped when path is empty) and the environment, and validates it.
+// Unknown keys in the file are errors, so typos do not pass silently.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to open config file: %w", err)
+		}
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		err = dec.Decode(&cfg)
+		f.Close()
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	env := envOverrides{}
+	env.apply(&cfg)
+	if err := errors.Join(env.errs...); err != nil {
+		return Config{}, fmt.Errorf("invalid environment:\n%w", err)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// envOverrides copies environment variables over the file settings,
+// collecting malformed values instead of ignoring them.
+type envOverrides struct {
+	errs []error
+}
+
+func (e *envOverrides) apply(cfg *Config) {
+	if port := os.Getenv("PORT"); port != "" {
+		cfg.Server.Listen = ":" + port
+	}
+	e.string("LISTEN_ADDR", &cfg.Server.Listen)
+	e.string("TLS_CERT_FILE", &cfg.Server.TLS.CertFile)
+	e.string("TLS_KEY_FILE", &cfg.Server.TLS.KeyFile)
+	e.duration("SERVER_READ_HEADER_TIMEOUT", &cfg.Server.ReadHeaderTimeout)
+	e.duration("SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	e.duration("SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	e.duration("SERVER_IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+	e.duration("SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+	e.list("CORS_ALLOWED_ORIGINS", &cfg.CORS.AllowedOrigins)
+
+	e.string("SIGHTING_STORE", &cfg.Store.Kind)
+	e.string("SIGHTING_FIXTURES", &cfg.Store.Fixtures)
+	e.string("SQLITE_PATH", &cfg.Store.SQLitePath)
+
+	e.string("DIG_OCEAN_DROPLET_DOCKER_PSQL", &cfg.Database.DSN)
+	e.int("DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns)
+	e.int("DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns)
+	e.duration("DB_CONN_MAX_LIFETIME", &cfg.Database.ConnMaxLifetime)
+	e.duration("DB_CONN_MAX_IDLE_TIME", &cfg.Database.ConnMaxIdleTime)
+	e.int("DB_QUERY_CONCURRENCY", &cfg.Database.QueryConcurrency)
+
+	e.string("TABLE_NAME_FORMAT", &cfg.Tables.DailyFormat)
+	e.string("LEGACY_TABLE", &cfg.Tables.Legacy)
+	e.string("TABLE_LAYOUT", &cfg.Tables.Layout)
+	e.duration("CATALOG_REFRESH_INTERVAL", &cfg.Catalog.RefreshInterval)
+
+	e.string("DESCOPE_PROJECT_ID", &cfg.Auth.DescopeProjectID)
+	e.list("DESCOPE_ROLES", &cfg.Auth.DescopeRoles)
+	e.string("AUTH_STATIC_TOKENS", &cfg.Auth.StaticTokens)
+
+	e.float("RATE_LIMIT_LIGHT_PER_MINUTE", &cfg.RateLimit.Light.PerMinute)
+	e.float("RATE_LIMIT_LIGHT_BURST", &cfg.RateLimit.Light.Burst)
+	e.float("RATE_LIMIT_HEAVY_PER_MINUTE", &cfg.RateLimit.Heavy.PerMinute)
+	e.float("RATE_LIMIT_HEAVY_BURST", &cfg.RateLimit.Heavy.Burst)
+	e.int64("API_KEY_DAILY_ROWS", &cfg.RateLimit.APIKeyDailyRows)
+	e.list("RATE_LIMIT_TRUSTED_PROXIES", &cfg.RateLimit.TrustedProxies)
+
+	e.bool("FEATURE_PUBLIC_ROUTES", &cfg.Features.PublicRoutes)
+	e.bool("FEATURE_API_KEYS", &cfg.Features.APIKeys)
+	e.bool("FEATURE_STREAMING", &cfg.Features.Streaming)
+	e.bool("FEATURE_METRICS", &cfg.Features.Metrics)
+
+	e.string("LOG_LEVEL", &cfg.Log.Level)
+	e.string("LOG_FORMAT", &cfg.Log.Format)
+}
+
+func (e *envOverrides) string(name string, dst *string) {
+	if v := os.Getenv(name); v != "" {
+		*dst = v
+	}
+}
+
+func (e *envOverrides) list(name string, dst *[]string) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*dst = list
+}
+
+func (e *envOverrides) int(name string, dst *int) {
+	var n int64
+	if e.parse(name, func(v string) (err error) { n, err = strconv.ParseInt(v, 10, 0); return err }) {
+		*dst = int(n)
+	}
+}
+
+func (e *envOverrides) int64(name string, dst *int64) {
+	e.parse(name, func(v string) (err error) { *dst, err = strconv.ParseInt(v, 10, 64); return err })
+}
+
+func (e *envOverrides) float(name string, dst *float64) {
+	e.parse(name, func(v string) (err error) { *dst, err = strconv.ParseFloat(v, 64); return err })
+}
+
+func (e *envOverrides) bool(name string, dst *bool) {
+	e.parse(name, func(v string) (err error) { *dst, err = strconv.ParseBool(v); return err })
+}
+
+func (e *envOverrides) duration(name string, dst *time.Duration) {
+	e.parse(name, func(v string) (err error) { *dst, err = time.ParseDuration(v); return err })
+}
+
+// parse runs set on the named variable when it is set and non-empty,
+// recording a failure. It reports whether set succeeded.
+func (e *envOverrides) parse(name string, set func(string) error) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return false
+	}
+	if err := set(v); err != nil {
+		e.errs = append(e.errs, fmt.Errorf("  %s=%q: %v", name, v, err))
+		return false
+	}
+	return true
+}
+
+// validate reports every problem with the configuration at once.
+func (c Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("  "+format, args...))
+		}
+	}
+
+	check(c.Server.Listen != "", "server.listen is required")
+	tls := c.Server.TLS
+	check((tls.CertFile == "") == (tls.KeyFile == ""), "server.tls needs both certFile and keyFile")
+	for _, file := range []string{tls.CertFile, tls.KeyFile} {
+		if file != "" {
+			_, err := os.Stat(file)
+			check(err == nil, "server.tls: %v", err)
+		}
+	}
+
+	for _, t := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"readHeaderTimeout", c.Server.ReadHeaderTimeout},
+		{"readTimeout", c.Server.ReadTimeout},
+		{"writeTimeout", c.Server.WriteTimeout},
+		{"idleTimeout", c.Server.IdleTimeout},
+	} {
+		check(t.value >= 0, "server.%s must not be negative (0 disables it)", t.name)
+	}
+	check(c.Server.ShutdownTimeout > 0, "server.shutdownTimeout must be positive")
+
+	check(len(c.CORS.AllowedOrigins) > 0, "cors.allowedOrigins must list at least one origin")
+	for _, origin := range c.CORS.AllowedOrigins {
+		check(origin == "*" || strings.HasPrefix(origin, "http://") || strings.HasPrefix(origin, "https://"),
+			"cors.allowedOrigins: %q must be * or start with http:// or https://", origin)
+	}
+	check(len(c.CORS.AllowedMethods) > 0, "cors.allowedMethods must list at least one method")
+
+	switch c.Store.Kind {
+	case "postgres":
+		db := c.Database
+		check(db.DSN != "", "database.dsn (DIG_OCEAN_DROPLET_DOCKER_PSQL) is required for the postgres store")
+		check(db.MaxOpenConns >= 1, "database.maxOpenConns must be at least 1, got %d", db.MaxOpenConns)
+		check(db.MaxIdleConns >= 0 && db.MaxIdleConns <= db.MaxOpenConns, "database.maxIdleConns must be between 0 and maxOpenConns, got %d", db.MaxIdleConns)
+		check(db.ConnMaxLifetime >= 0, "database.connMaxLifetime must not be negative")
+		check(db.ConnMaxIdleTime >= 0, "database.connMaxIdleTime must not be negative")
+		check(db.QueryConcurrency >= 1 && db.QueryConcurrency <= db.MaxOpenConns, "database.queryConcurrency must be between 1 and maxOpenConns, got %d", db.QueryConcurrency)
+	case "memory":
+	case "sqlite":
+		check(c.Store.SQLitePath != "", "store.sqlitePath is required for the sqlite store")
+	default:
+		check(false, "store.kind must be postgres, memory or sqlite, got %q", c.Store.Kind)
+	}
+
+	_, err := newTableNaming(c.Tables.DailyFormat)
+	check(err == nil, "tables.dailyFormat: %v", err)
+	check(c.Tables.Legacy != "" && !strings.Contains(c.Tables.Legacy, `"`), "tables.legacy must be a table name, got %q", c.Tables.Legacy)
+	switch c.Tables.Layout {
+	case layoutAuto, layoutDaily, layoutPartitioned:
+	default:
+		check(false, "tables.layout must be auto, daily or partitioned, got %q", c.Tables.Layout)
+	}
+
+	check(c.Catalog.RefreshInterval > 0, "catalog.refreshInterval must be positive")
+
+	for _, class := range []string{routeLight, routeHeavy} {
+		limit := c.RateLimit.limit(class)
+		check(limit.PerMinute > 0, "rateLimit.%s.perMinute must be positive", class)
+		check(limit.Burst >= 1, "rateLimit.%s.burst must be at least 1", class)
+	}
+	check(c.RateLimit.APIKeyDailyRows >= 0, "rateLimit.apiKeyDailyRows must not be negative")
+	_, err = parseTrustedProxies(c.RateLimit.TrustedProxies)
+	check(err == nil, "rateLimit.trustedProxies: %v", err)
+
+	_, err = newLogger(c.Log, io.Discard)
+	check(err == nil, "log: %v", err)
+
+	if c.Auth.StaticTokens != "" {
+		_, err := os.Stat(c.Auth.StaticTokens)
+		check(err == nil, "auth.staticTokens: %v", err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runConsolidate implements "monarchbutterfly consolidate": it copies the
+// daily tables, and any extra tables named with -extra, into the partitioned
+// sightings table. Each source table is copied in its own transaction and
+// tagged in source_table. Rows already in the sightings table under the
+// same record key and day are skipped, so an interrupted run can simply be
+// repeated; -replace deletes a table's earlier copy first.
+func runConsolidate(args []string) error {
+	flags := flag.NewFlagSet("consolidate", flag.ExitOnError)
+	configPath := flags.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	startFlag := flags.String("start", "", "first day to copy; defaults to the earliest daily table")
+	endFlag := flags.String("end", "", "last day to copy; defaults to the latest daily table")
+	extraFlag := flags.String("extra", "", "comma-separated tables outside the daily naming scheme to copy as well")
+	replace := flags.Bool("replace", false, "copy tables again, replacing rows consolidated earlier")
+	dryRun := flags.Bool("dry-run", false, "list the tables that would be copied without writing anything")
+	flags.Parse(args)
+
+	cfg := initConfig(*configPath)
+	if cfg.Store.Kind != "postgres" {
+		return fmt.Errorf("consolidate needs the postgres store, got %q", cfg.Store.Kind)
+	}
+
+	now := time.Now()
+	var start, end *calendarDate
+	for _, bound := range []struct {
+		flag string
+		dest **calendarDate
+	}{{*startFlag, &start}, {*endFlag, &end}} {
+		if bound.flag == "" {
+			continue
+		}
+		day, err := parseDate(bound.flag, now)
+		if err != nil {
+			return err
+		}
+		*bound.dest = &day
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	naming, err := newTableNaming(cfg.Tables.DailyFormat)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := checkSchema(ctx, db); err != nil {
+		return err
+	}
+
+	sources, err := consolidateSources(ctx, newPostgresStore(db, 1, naming, cfg.Tables.Legacy), start, end, splitList(*extraFlag))
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		var total int64
+		for _, t := range sources {
+			slog.Info("would consolidate table", "table", t.Name, "rows", t.RowCount)
+			total += t.RowCount
+		}
+		slog.Info("dry run finished", "tables", len(sources), "rows", total)
+		return nil
+	}
+
+	var total int64
+	for _, t := range sources {
+		n, err := consolidateTable(ctx, db, t.Name, *replace)
+		if err != nil {
+			return fmt.Errorf("consolidating %s: %w", t.Name, err)
+		}
+		slog.Info("consolidated table", "table", t.Name, "rows", n)
+		total += n
+	}
+
+	if _, err := db.ExecContext(ctx, `ANALYZE "sightings"`); err != nil {
+		return fmt.Errorf("failed to analyze sightings: %w", err)
+	}
+	slog.Info("consolidation finished", "tables", len(sources), "rows", total)
+	return nil
+}
+
+// consolidateSources returns the daily tables between start and end (either
+// may be nil for no bound) followed by the extra tables, which must exist
+// and have a date_only column.
+func consolidateSources(ctx context.Context, daily *postgresStore, start, end *calendarDate, extra []string) ([]TableInfo, error) {
+	tables, err := daily.Tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []TableInfo
+	byName := make(map[string]TableInfo)
+	for _, t := range tables {
+		byName[t.Name] = t
+		if t.Kind != tableKindDaily {
+			continue
+		}
+		if (start != nil && t.Date < start.String()) || (end != nil && t.Date > end.String()) {
+			continue
+		}
+		sources = append(sources, t)
+	}
+	for _, name := range extra {
+		t, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("table %q does not exist or has no date_only column", name)
+		}
+		if t.Kind == tableKindDaily {
+			return nil, fmt.Errorf("table %q is a daily table; select it with -start and -end", name)
+		}
+		sources = append(sources, t)
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("no tables to consolidate")
+	}
+	return sources, nil
+}
+
+// consolidateTable copies the rows of table into the partitioned table in
+// one transaction and returns how many it copied. Only the columns the two
+// tables share are copied, cast to the partitioned table's types, and each
+// row is keyed as it is copied; rows without a date_only are left behind.
+// A row whose key is already in the partitioned table on the same day is
+// skipped, and of several rows sharing a key and day only one is copied.
+// Rows without a key cannot be matched, so they are only copied while the
+// partitioned table has no keyless rows from table. With replace, the rows
+// an earlier run copied from table are deleted first.
+func consolidateTable(ctx context.Context, db *sql.DB, table string, replace bool) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, importLockID); err != nil {
+		return 0, fmt.Errorf("failed to lock the sightings tables: %w", err)
+	}
+
+	if replace {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM "sightings" WHERE "source_table" = $1`, table); err != nil {
+			return 0, err
+		}
+	}
+
+	have, err := tableColumns(ctx, tx, table)
+	if err != nil {
+		return 0, err
+	}
+	// The source's shared columns, plus the ones the record key is built
+	// from when the source lacks them.
+	var names, values, keyed []string
+	for _, c := range slices.Concat(sightingColumns, trackingColumns) {
+		if have[c.name] {
+			names = append(names, fmt.Sprintf(`"%s"`, c.name))
+			values = append(values, fmt.Sprintf(`CAST("%s" AS %s) AS "%[1]s"`, c.name, c.pgType))
+		}
+	}
+	for _, name := range []string{"gbifID", "datasetKey", "occurrenceID"} {
+		if !have[name] {
+			values = append(values, fmt.Sprintf(`CAST(NULL AS TEXT) AS "%s"`, name))
+		}
+	}
+	key := recordKeyExpr
+	if have["record_key"] {
+		key = `COALESCE("record_key", ` + recordKeyExpr + `)`
+	} else {
+		names = append(names, `"record_key"`)
+	}
+	for _, name := range names {
+		if name == `"record_key"` {
+			keyed = append(keyed, key+` AS "record_key"`)
+		} else {
+			keyed = append(keyed, name)
+		}
+	}
+
+	var first, last sql.NullString
+	var undated int64
+	query := fmt.Sprintf(`SELECT CAST(MIN(CAST("date_only" AS DATE)) AS TEXT), CAST(MAX(CAST("date_only" AS DATE)) AS TEXT), COUNT(*) - COUNT("date_only") FROM "%s"`, table)
+	if err := tx.QueryRowContext(ctx, query).Scan(&first, &last, &undated); err != nil {
+		return 0, err
+	}
+	if undated > 0 {
+		slog.WarnContext(ctx, "skipping rows without date_only", "table", table, "rows", undated)
+	}
+	if !first.Valid {
+		return 0, tx.Commit()
+	}
+	firstDay, err := time.Parse("2006-01-02", first.String)
+	if err != nil {
+		return 0, err
+	}
+	lastDay, err := time.Parse("2006-01-02", last.String)
+	if err != nil {
+		return 0, err
+	}
+	if err := ensurePartitions(ctx, tx, dateOf(firstDay), dateOf(lastDay)); err != nil {
+		return 0, err
+	}
+
+	insert := fmt.Sprintf(`INSERT INTO "sightings" (%[1]s, "source_table")
+		SELECT %[1]s, $1 FROM (
+			SELECT *, ROW_NUMBER() OVER (PARTITION BY "record_key", "date_only") AS "copy"
+			FROM (SELECT %[2]s FROM (SELECT %[3]s FROM "%[4]s" WHERE "date_only" IS NOT NULL) c) r
+		) k
+		WHERE CASE WHEN "record_key" IS NULL
+			THEN NOT EXISTS (SELECT 1 FROM "sightings" s WHERE s."source_table" = $1 AND s."record_key" IS NULL)
+			ELSE "copy" = 1 AND NOT EXISTS (SELECT 1 FROM "sightings" s WHERE s."record_key" = k."record_key" AND s."date_only" = k."date_only")
+		END`,
+		strings.Join(names, ", "), strings.Join(keyed, ", "), strings.Join(values, ", "), table)
+	res, err := tx.ExecContext(ctx, insert, table)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// tableColumns returns the column names of table in the current schema.
+func tableColumns(ctx context.Context, tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
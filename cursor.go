@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SightingCursor is a position in the (date_only, time_only, gbifID) order
+// every multi-day query returns. A page continues with the rows strictly
+// after it.
+type SightingCursor struct {
+	Date   string
+	Time   string
+	GBIFID string
+}
+
+// orderByKeys is the SQL form of the cursor order. Casting to text keeps the
+// comparison identical to the string comparison used in memory, and the
+// COALESCEs keep rows with null times or IDs in the page sequence.
+const orderByKeys = `CAST("date_only" AS TEXT), COALESCE(CAST("time_only" AS TEXT), ''), COALESCE(CAST("gbifID" AS TEXT), '')`
+
+// cursorFor returns the cursor positioned at record.
+func cursorFor(record MyMonarchRecord) SightingCursor {
+	return SightingCursor{
+		Date:   recordDate(record),
+		Time:   timeKey(deref(record.TimeOnly)),
+		GBIFID: deref(record.GBIFID),
+	}
+}
+
+// timeKey trims a time_only value down to its clock time. Drivers hand a
+// "time without time zone" column back either as "13:41:03" or as a full
+// timestamp on year zero.
+func timeKey(s string) string {
+	if i := strings.IndexByte(s, 'T'); i >= 0 {
+		s = strings.TrimSuffix(s[i+1:], "Z")
+	}
+	return s
+}
+
+// less reports whether c sorts before o.
+func (c SightingCursor) less(o SightingCursor) bool {
+	if c.Date != o.Date {
+		return c.Date < o.Date
+	}
+	if c.Time != o.Time {
+		return c.Time < o.Time
+	}
+	return c.GBIFID < o.GBIFID
+}
+
+// Encode returns the opaque form of c handed to clients.
+func (c SightingCursor) Encode() string {
+	data, _ := json.Marshal([]string{c.Date, c.Time, c.GBIFID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor produced by Encode.
+func decodeCursor(s string) (SightingCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return SightingCursor{}, fmt.Errorf("malformed cursor")
+	}
+	var parts []string
+	if err := json.Unmarshal(data, &parts); err != nil || len(parts) != 3 || len(parts[0]) != 10 {
+		return SightingCursor{}, fmt.Errorf("malformed cursor")
+	}
+	return SightingCursor{Date: parts[0], Time: parts[1], GBIFID: parts[2]}, nil
+}
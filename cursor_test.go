@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, c := range []SightingCursor{
+		{Date: "2025-06-21", Time: "13:41:03", GBIFID: "4812300334"},
+		{Date: "2025-06-21", Time: "", GBIFID: ""},
+		{Date: "2025-06-21", Time: "07:14:02", GBIFID: `id with "quotes", commas`},
+	} {
+		got, err := decodeCursor(c.Encode())
+		if err != nil {
+			t.Fatalf("decodeCursor(%+v): %v", c, err)
+		}
+		if got != c {
+			t.Errorf("round trip of %+v gave %+v", c, got)
+		}
+	}
+	for _, bad := range []string{"", "not base64!", "bnVsbA", SightingCursor{Date: "0621"}.Encode()} {
+		if _, err := decodeCursor(bad); err == nil {
+			t.Errorf("decodeCursor(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestCursorTimeKey(t *testing.T) {
+	for in, want := range map[string]string{
+		"13:41:03":             "13:41:03",
+		"0000-01-01T13:41:03Z": "13:41:03",
+		"":                     "",
+	} {
+		if got := timeKey(in); got != want {
+			t.Errorf("timeKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestKeysetPagination(t *testing.T) {
+	records := fixtureRecords(t)
+	// Two sightings at the same time on the same day are told apart by
+	// their gbifID.
+	tie := records[0]
+	tie.GBIFID = ptr("4812300333")
+	records = append(records, tie)
+
+	for name, store := range testStores(t, records) {
+		t.Run(name, func(t *testing.T) {
+			all, err := store.Filter(context.Background(), SightingFilter{Start: fixtureStart, End: fixtureEnd})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all.Records) != len(records) {
+				t.Fatalf("unpaged query returned %d records, want %d", len(all.Records), len(records))
+			}
+			for i := 1; i < len(all.Records); i++ {
+				if !cursorFor(all.Records[i-1]).less(cursorFor(all.Records[i])) {
+					t.Fatalf("records %d and %d are out of cursor order", i-1, i)
+				}
+			}
+
+			srv := &server{store: store}
+			var paged []string
+			cursor, pages := "", 0
+			for {
+				query := "start=06192025&end=06232025&limit=4"
+				if cursor != "" {
+					query += "&cursor=" + cursor
+				}
+				rec := getRange(srv, query)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("page %d: status = %d: %s", pages, rec.Code, rec.Body)
+				}
+				var body struct {
+					Records    []MyMonarchRecord `json:"records"`
+					NextCursor string            `json:"nextCursor"`
+				}
+				if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+				for _, r := range body.Records {
+					paged = append(paged, *r.GBIFID)
+				}
+				pages++
+				if body.NextCursor == "" {
+					break
+				}
+				if len(body.Records) != 4 {
+					t.Fatalf("page %d has %d records and a next cursor", pages, len(body.Records))
+				}
+				cursor = body.NextCursor
+			}
+
+			if want := (len(records) + 3) / 4; pages != want {
+				t.Errorf("read %d pages, want %d", pages, want)
+			}
+			if len(paged) != len(all.Records) {
+				t.Fatalf("pages hold %d records, want %d", len(paged), len(all.Records))
+			}
+			for i, r := range all.Records {
+				if paged[i] != *r.GBIFID {
+					t.Fatalf("record %d is %s, want %s", i, paged[i], *r.GBIFID)
+				}
+			}
+		})
+	}
+}
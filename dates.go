@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// calendarDate is a day on the calendar, with no time of day or zone. Every
+// accepted date input is normalized to one before it picks a table or
+// bounds a query.
+type calendarDate struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// newCalendarDate returns the date, or false when it does not exist, such as
+// February 31st.
+func newCalendarDate(year int, month time.Month, day int) (calendarDate, bool) {
+	t := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if t.Year() != year || t.Month() != month || t.Day() != day {
+		return calendarDate{}, false
+	}
+	return calendarDate{Year: year, Month: month, Day: day}, true
+}
+
+// dateOf returns the calendar day of t in t's location.
+func dateOf(t time.Time) calendarDate {
+	return calendarDate{Year: t.Year(), Month: t.Month(), Day: t.Day()}
+}
+
+// Time returns midnight UTC at the start of d, the form stores use for days.
+func (d calendarDate) Time() time.Time {
+	return time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, time.UTC)
+}
+
+// AddDays returns the date n days after d; n may be negative.
+func (d calendarDate) AddDays(n int) calendarDate {
+	return dateOf(d.Time().AddDate(0, 0, n))
+}
+
+// String formats d as YYYY-MM-DD.
+func (d calendarDate) String() string {
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, int(d.Month), d.Day)
+}
+
+// maxRelativeDays bounds relative dates such as -7d.
+const maxRelativeDays = 36600
+
+// dateFormatsHelp lists the accepted date formats for error messages.
+const dateFormatsHelp = "YYYY-MM-DD, MMDDYYYY, YYYYMMDD, today, yesterday or -Nd"
+
+// parseDate parses a date in any of the accepted forms:
+//
+//	2025-06-21            ISO 8601 date (a full RFC 3339 timestamp also works)
+//	06212025              MMDDYYYY, as the dayscan route always took
+//	20250621              YYYYMMDD
+//	today, yesterday      relative to now, in UTC
+//	-7d                   that many days before today
+//
+// MMDDYYYY and YYYYMMDD cannot be confused: no month starts with 19 or 20,
+// and a YYYYMMDD year must start with one of them.
+func parseDate(s string, now time.Time) (calendarDate, error) {
+	s = strings.TrimSpace(s)
+	today := dateOf(now.UTC())
+
+	switch strings.ToLower(s) {
+	case "":
+		return calendarDate{}, fmt.Errorf("missing date (want %s)", dateFormatsHelp)
+	case "today":
+		return today, nil
+	case "yesterday":
+		return today.AddDays(-1), nil
+	}
+
+	if days, ok := strings.CutPrefix(s, "-"); ok {
+		if days, ok := strings.CutSuffix(strings.ToLower(days), "d"); ok {
+			n, err := strconv.Atoi(days)
+			if err != nil || n < 0 || n > maxRelativeDays {
+				return calendarDate{}, fmt.Errorf("invalid relative date %q (want -Nd, e.g. -7d)", s)
+			}
+			return today.AddDays(-n), nil
+		}
+	}
+
+	if len(s) == 8 && isDigits(s) {
+		var year, month, day int
+		if s[:2] == "19" || s[:2] == "20" {
+			year, month, day = atoi(s[0:4]), atoi(s[4:6]), atoi(s[6:8])
+		} else {
+			month, day, year = atoi(s[0:2]), atoi(s[2:4]), atoi(s[4:8])
+		}
+		return checkedDate(s, year, time.Month(month), day)
+	}
+
+	if len(s) == 10 && s[4] == '-' && s[7] == '-' {
+		year, month, day := s[0:4], s[5:7], s[8:10]
+		if isDigits(year + month + day) {
+			return checkedDate(s, atoi(year), time.Month(atoi(month)), atoi(day))
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return dateOf(t), nil
+	}
+	return calendarDate{}, fmt.Errorf("unrecognized date %q (want %s)", s, dateFormatsHelp)
+}
+
+// checkedDate builds a date parsed from s, rejecting impossible ones.
+func checkedDate(s string, year int, month time.Month, day int) (calendarDate, error) {
+	d, ok := newCalendarDate(year, month, day)
+	if !ok {
+		return calendarDate{}, fmt.Errorf("%q is not a real calendar date", s)
+	}
+	return d, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// atoi converts a string already checked by isDigits.
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	// 23:30 in Chicago is already the next day in UTC, which relative dates
+	// count from.
+	now := time.Date(2025, 6, 21, 23, 30, 0, 0, time.FixedZone("CDT", -5*3600))
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"2025-06-21", "2025-06-21"},
+		{" 2025-06-21 ", "2025-06-21"},
+		{"2025-06-21T22:00:00-05:00", "2025-06-21"},
+		{"06212025", "2025-06-21"},
+		{"20250621", "2025-06-21"},
+		{"01012000", "2000-01-01"},
+		{"19991231", "1999-12-31"},
+		{"2024-02-29", "2024-02-29"},
+		{"today", "2025-06-22"},
+		{"Yesterday", "2025-06-21"},
+		{"-0d", "2025-06-22"},
+		{"-7d", "2025-06-15"},
+		{"-30D", "2025-05-23"},
+	}
+	for _, tt := range tests {
+		got, err := parseDate(tt.in, now)
+		if err != nil {
+			t.Errorf("parseDate(%q): %v", tt.in, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("parseDate(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseDateRejects(t *testing.T) {
+	now := time.Date(2025, 6, 21, 12, 0, 0, 0, time.UTC)
+	for _, in := range []string{
+		"",
+		"2025-02-30",
+		"2025-02-29",
+		"2025-13-01",
+		"2025-00-10",
+		"02302025",
+		"13012025",
+		"20250230",
+		"2025/06/21",
+		"2025-6-21",
+		"june 21",
+		"0621202",
+		"-d",
+		"-7",
+		"-x7d",
+		"-99999d",
+	} {
+		if got, err := parseDate(in, now); err == nil {
+			t.Errorf("parseDate(%q) = %s, want an error", in, got)
+		}
+	}
+}
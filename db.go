@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// dbConfig holds the settings for the shared Postgres connection pool.
+type dbConfig struct {
+	DSN             string        `yaml:"dsn"`
+	MaxOpenConns    int           `yaml:"maxOpenConns"`
+	MaxIdleConns    int           `yaml:"maxIdleConns"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"connMaxIdleTime"`
+
+	// QueryConcurrency is how many daily tables a multi-day query reads in
+	// parallel. It should stay below MaxOpenConns.
+	QueryConcurrency int `yaml:"queryConcurrency"`
+}
+
+// openDB opens the pooled database handle used by every handler and checks
+// that the database is reachable before the server starts accepting requests.
+func openDB(cfg dbConfig) (*sql.DB, error) {
+	if cfg.DSN == "" {
+		return nil, fmt.Errorf("database.dsn (DIG_OCEAN_DROPLET_DOCKER_PSQL) is not set")
+	}
+
+	db, err := sql.Open("postgres", cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, &storeError{Stage: stageConnect, Err: fmt.Errorf("database ping failed: %w", err)}
+	}
+
+	slog.Info("database pool ready", "max_open", cfg.MaxOpenConns, "max_idle", cfg.MaxIdleConns)
+	return db, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportColumns is the header of CSV and TSV exports: the JSON names of the
+// 35 MyMonarchRecord fields, in monarchColumns order.
+var exportColumns = strings.Split(strings.ReplaceAll(monarchColumns, `"`, ""), ", ")
+
+// delimitedFormat writes records as CSV (RFC 4180, CRLF line endings) or
+// TSV. Missing values are empty cells.
+type delimitedFormat struct {
+	comma rune
+}
+
+var (
+	csvFormat = delimitedFormat{comma: ','}
+	tsvFormat = delimitedFormat{comma: '\t'}
+)
+
+func (f delimitedFormat) contentType() string {
+	if f.comma == '\t' {
+		return "text/tab-separated-values; charset=utf-8"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+func (f delimitedFormat) extension() string {
+	if f.comma == '\t' {
+		return "tsv"
+	}
+	return "csv"
+}
+
+// filename names the download after the requested dates, e.g.
+// monarchs_2025-06-21.csv or monarchs_2025-06-19_2025-06-23.csv.
+func (f delimitedFormat) filename(meta *responseMeta) string {
+	if meta.Start == meta.End {
+		return fmt.Sprintf("monarchs_%s.%s", meta.Start, f.extension())
+	}
+	return fmt.Sprintf("monarchs_%s_%s.%s", meta.Start, meta.End, f.extension())
+}
+
+func (f delimitedFormat) writer(w io.Writer) *csv.Writer {
+	cw := csv.NewWriter(w)
+	cw.Comma = f.comma
+	cw.UseCRLF = f.comma == ','
+	return cw
+}
+
+func (f delimitedFormat) open(w io.Writer, _ *responseMeta) error {
+	cw := f.writer(w)
+	cw.Write(exportColumns)
+	cw.Flush()
+	return cw.Error()
+}
+
+func (f delimitedFormat) record(w io.Writer, record MyMonarchRecord, _ bool) (bool, error) {
+	values := recordValues(record)
+	row := make([]string, len(values))
+	for i, v := range values {
+		row[i] = formatCell(v)
+	}
+
+	cw := f.writer(w)
+	cw.Write(row)
+	cw.Flush()
+	return true, cw.Error()
+}
+
+func (delimitedFormat) close(io.Writer, *responseMeta) error {
+	return nil
+}
+
+// formatCell renders one value from recordValues as text.
+func formatCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDelimitedFormats(t *testing.T) {
+	record := sighting("4812300334", 35.48195, -97.57012)
+	record.IndividualCount = ptr(int64(2))
+	record.CityOrTown = ptr(`Oklahoma City, "OKC"`)
+
+	for _, f := range []delimitedFormat{csvFormat, tsvFormat} {
+		var buf bytes.Buffer
+		if err := f.open(&buf, &responseMeta{}); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := f.record(&buf, record, true); !ok || err != nil {
+			t.Fatalf("record = %v, %v", ok, err)
+		}
+		if err := f.close(&buf, &responseMeta{}); err != nil {
+			t.Fatal(err)
+		}
+
+		out := buf.String()
+		if crlf := strings.Contains(out, "\r\n"); crlf != (f == csvFormat) {
+			t.Errorf("%s: CRLF line endings = %v", f.extension(), crlf)
+		}
+		r := csv.NewReader(&buf)
+		r.Comma = f.comma
+		rows, err := r.ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", f.extension(), err, out)
+		}
+		if len(rows) != 2 || strings.Join(rows[0], ",") != strings.Join(exportColumns, ",") {
+			t.Fatalf("%s: got %d rows with header %v", f.extension(), len(rows), rows[0])
+		}
+		cells := make(map[string]string)
+		for i, name := range rows[0] {
+			cells[name] = rows[1][i]
+		}
+		for name, want := range map[string]string{
+			"gbifID":           "4812300334",
+			"date_only":        "2025-06-21",
+			"decimalLatitude":  "35.48195",
+			"individualCount":  "2",
+			"cityOrTown":       `Oklahoma City, "OKC"`,
+			"stateProvince":    "",
+			"decimalLongitude": "-97.57012",
+		} {
+			if cells[name] != want {
+				t.Errorf("%s: %s = %q, want %q", f.extension(), name, cells[name], want)
+			}
+		}
+	}
+}
+
+func TestExportHeadersAndTrailers(t *testing.T) {
+	for name, store := range testStores(t, fixtureRecords(t)) {
+		t.Run(name, func(t *testing.T) {
+			srv := &server{store: store, streaming: true}
+
+			rec := getRange(srv, "start=06192025&end=06232025&format=tsv")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tsvFormat.contentType() {
+				t.Errorf("Content-Type = %q", got)
+			}
+			if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename=monarchs_2025-06-19_2025-06-23.tsv` {
+				t.Errorf("Content-Disposition = %q", got)
+			}
+			if lines := strings.Count(rec.Body.String(), "\n"); lines != 26 {
+				t.Errorf("export has %d lines, want a header and 25 rows", lines)
+			}
+
+			// A streamed page only knows its next cursor at the end, so it
+			// comes in the trailers.
+			rec = getRange(srv, "start=06192025&end=06232025&format=csv&stream=true&limit=10")
+			resp := rec.Result()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d: %s", resp.StatusCode, rec.Body)
+			}
+			if got := resp.Header.Get("Trailer"); got != "Link, X-Next-Cursor" {
+				t.Errorf("Trailer = %q", got)
+			}
+			rows, err := csv.NewReader(rec.Body).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 11 {
+				t.Fatalf("page has %d lines, want a header and 10 rows", len(rows))
+			}
+			next := resp.Trailer.Get("X-Next-Cursor")
+			cursor, err := decodeCursor(next)
+			if err != nil {
+				t.Fatalf("X-Next-Cursor %q: %v", next, err)
+			}
+			last := rows[len(rows)-1]
+			if cursor.GBIFID != last[0] {
+				t.Errorf("next cursor is at %s, want the last row %s", cursor.GBIFID, last[0])
+			}
+			if link := resp.Trailer.Get("Link"); !strings.Contains(link, "cursor="+next) || !strings.HasSuffix(link, `rel="next"`) {
+				t.Errorf("Link trailer = %q", link)
+			}
+
+			// The last page has no next cursor.
+			for _, want := range []int{10, 5} {
+				rec = getRange(srv, "start=06192025&end=06232025&format=csv&stream=true&limit=10&cursor="+next)
+				resp = rec.Result()
+				if rows, _ := csv.NewReader(rec.Body).ReadAll(); len(rows) != want+1 {
+					t.Fatalf("page has %d lines, want a header and %d rows", len(rows), want)
+				}
+				next = resp.Trailer.Get("X-Next-Cursor")
+			}
+			if next != "" || resp.Trailer.Get("Link") != "" {
+				t.Errorf("last page has next cursor %q", next)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// parseSightingFilter reads the field filters shared by the sightings
+// endpoints from the query string. The caller sets the date range.
+//
+// Supported parameters: stateProvince, county, cityOrTown, countryCode,
+// basisOfRecord, datasetKey, recordedBy, minIndividualCount,
+// maxCoordinateUncertaintyInMeters, bbox, near with radiusKm, and
+// includeUncertainty.
+func parseSightingFilter(r *http.Request) (SightingFilter, error) {
+	query := r.URL.Query()
+
+	f := SightingFilter{
+		StateProvince: strings.TrimSpace(query.Get("stateProvince")),
+		County:        strings.TrimSpace(query.Get("county")),
+		CityOrTown:    strings.TrimSpace(query.Get("cityOrTown")),
+		CountryCode:   strings.TrimSpace(query.Get("countryCode")),
+		BasisOfRecord: normalizeBasisOfRecord(query.Get("basisOfRecord")),
+		DatasetKey:    strings.TrimSpace(query.Get("datasetKey")),
+		RecordedBy:    strings.TrimSpace(query.Get("recordedBy")),
+	}
+
+	if v := query.Get("minIndividualCount"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return SightingFilter{}, fmt.Errorf("minIndividualCount must be a non-negative integer, got %q", v)
+		}
+		f.MinIndividualCount = &n
+	}
+	if v := query.Get("maxCoordinateUncertaintyInMeters"); v != "" {
+		m, err := strconv.ParseFloat(v, 64)
+		if err != nil || m < 0 {
+			return SightingFilter{}, fmt.Errorf("maxCoordinateUncertaintyInMeters must be a non-negative number, got %q", v)
+		}
+		f.MaxCoordinateUncertainty = &m
+	}
+	if v := query.Get("bbox"); v != "" {
+		b, err := parseBoundingBox(v)
+		if err != nil {
+			return SightingFilter{}, err
+		}
+		f.BBox = b
+	}
+	if v := query.Get("near"); v != "" {
+		n, err := parseRadiusFilter(v, query.Get("radiusKm"))
+		if err != nil {
+			return SightingFilter{}, err
+		}
+		f.Near = n
+	} else if query.Get("radiusKm") != "" {
+		return SightingFilter{}, fmt.Errorf("radiusKm requires near")
+	}
+	if v := query.Get("includeUncertainty"); v != "" {
+		include, err := strconv.ParseBool(v)
+		if err != nil {
+			return SightingFilter{}, fmt.Errorf("includeUncertainty must be true or false, got %q", v)
+		}
+		f.IncludeUncertainty = include
+	}
+	return f, nil
+}
+
+// normalizeBasisOfRecord turns friendly spellings such as "human observation"
+// into the GBIF vocabulary value HUMAN_OBSERVATION.
+func normalizeBasisOfRecord(v string) string {
+	v = strings.TrimSpace(v)
+	return strings.ToUpper(strings.Join(strings.Fields(v), "_"))
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gbifSource reads the occurrence rows of a GBIF download: a Darwin Core
+// Archive, a zipped simple CSV download, or an unzipped occurrence file.
+type gbifSource struct {
+	// columns names each field of a row by its Darwin Core term, e.g.
+	// "gbifID" or "eventDate".
+	columns []string
+	next    func() ([]string, error)
+	closers []io.Closer
+}
+
+// Next returns the next row, or io.EOF after the last one.
+func (s *gbifSource) Next() ([]string, error) {
+	return s.next()
+}
+
+func (s *gbifSource) Close() error {
+	var errs []error
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		errs = append(errs, s.closers[i].Close())
+	}
+	return errors.Join(errs...)
+}
+
+// openGBIFSource opens the download at name. Zip files are read through
+// their meta.xml when they are Darwin Core Archives, or else through the
+// largest .csv or .txt file they hold, as in a simple CSV download.
+func openGBIFSource(name string) (*gbifSource, error) {
+	if pathExt(name) == ".zip" {
+		return openGBIFArchive(name)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newDelimitedSource(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	s.closers = append(s.closers, f)
+	return s, nil
+}
+
+func pathExt(name string) string {
+	return path.Ext(strings.ToLower(name))
+}
+
+func openGBIFArchive(name string) (*gbifSource, error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta, data *zip.File
+	for _, f := range zr.File {
+		switch {
+		case path.Base(f.Name) == "meta.xml":
+			meta = f
+		case pathExt(f.Name) == ".csv" || pathExt(f.Name) == ".txt":
+			if data == nil || f.UncompressedSize64 > data.UncompressedSize64 {
+				data = f
+			}
+		}
+	}
+
+	var s *gbifSource
+	switch {
+	case meta != nil:
+		s, err = openDwCACore(zr, meta)
+	case data != nil:
+		var r io.ReadCloser
+		r, err = data.Open()
+		if err == nil {
+			s, err = newDelimitedSource(r)
+			if err != nil {
+				r.Close()
+			} else {
+				s.closers = append(s.closers, r)
+			}
+		}
+	default:
+		err = errors.New("no meta.xml, .csv or .txt file in the archive")
+	}
+	if err != nil {
+		zr.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	s.closers = append([]io.Closer{zr}, s.closers...)
+	return s, nil
+}
+
+// dwcaMeta is the part of a Darwin Core Archive's meta.xml describing its
+// core data file.
+type dwcaMeta struct {
+	Core struct {
+		FieldsTerminatedBy string `xml:"fieldsTerminatedBy,attr"`
+		FieldsEnclosedBy   string `xml:"fieldsEnclosedBy,attr"`
+		IgnoreHeaderLines  int    `xml:"ignoreHeaderLines,attr"`
+		Location           string `xml:"files>location"`
+		Fields             []struct {
+			Index *int   `xml:"index,attr"`
+			Term  string `xml:"term,attr"`
+		} `xml:"field"`
+	} `xml:"core"`
+}
+
+// openDwCACore opens the core file named by meta.xml, naming its columns
+// after the terms meta.xml maps them to.
+func openDwCACore(zr *zip.ReadCloser, metaFile *zip.File) (*gbifSource, error) {
+	r, err := metaFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	var meta dwcaMeta
+	err = xml.NewDecoder(r).Decode(&meta)
+	r.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading meta.xml: %w", err)
+	}
+	core := meta.Core
+	if core.Location == "" {
+		return nil, errors.New("meta.xml names no core file")
+	}
+
+	var columns []string
+	for _, f := range core.Fields {
+		if f.Index == nil {
+			continue
+		}
+		for len(columns) <= *f.Index {
+			columns = append(columns, "")
+		}
+		columns[*f.Index] = dwcTermName(f.Term)
+	}
+
+	delim := unescapeMeta(core.FieldsTerminatedBy)
+	if delim == "" {
+		delim = ","
+	}
+	dataFile, err := zr.Open(path.Join(path.Dir(metaFile.Name), core.Location))
+	if err != nil {
+		return nil, err
+	}
+	next := delimitedRows(bufio.NewReader(dataFile), []rune(delim)[0], core.FieldsEnclosedBy != "")
+	for i := 0; i < core.IgnoreHeaderLines; i++ {
+		if _, err := next(); err != nil {
+			dataFile.Close()
+			return nil, fmt.Errorf("reading %s: %w", core.Location, err)
+		}
+	}
+	return &gbifSource{columns: columns, next: next, closers: []io.Closer{dataFile}}, nil
+}
+
+// dwcTermName returns the short name of a term URI, e.g. eventDate for
+// http://rs.tdwg.org/dwc/terms/eventDate.
+func dwcTermName(term string) string {
+	if i := strings.LastIndexAny(term, "/#"); i >= 0 {
+		return term[i+1:]
+	}
+	return term
+}
+
+// unescapeMeta decodes the escapes meta.xml uses for delimiters.
+func unescapeMeta(s string) string {
+	return strings.NewReplacer(`\t`, "\t", `\n`, "\n", `\r`, "\r").Replace(s)
+}
+
+// newDelimitedSource reads a file whose first line names its columns. Tab
+// separated files, as GBIF writes them, are split on tabs without quoting;
+// anything else is read as quoted comma-separated values.
+func newDelimitedSource(r io.Reader) (*gbifSource, error) {
+	br := bufio.NewReader(r)
+	header, err := br.ReadString('\n')
+	if err != nil && (err != io.EOF || header == "") {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	header = strings.TrimPrefix(header, "\ufeff")
+
+	var next func() ([]string, error)
+	if strings.Contains(header, "\t") {
+		next = delimitedRows(io.MultiReader(strings.NewReader(header), br), '\t', false)
+	} else {
+		next = delimitedRows(io.MultiReader(strings.NewReader(header), br), ',', true)
+	}
+	columns, err := next()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	for i, c := range columns {
+		columns[i] = strings.TrimSpace(c)
+	}
+	return &gbifSource{columns: columns, next: next}, nil
+}
+
+// delimitedRows returns a function reading one row at a time from r. Empty
+// lines are skipped.
+func delimitedRows(r io.Reader, delim rune, quoted bool) func() ([]string, error) {
+	if quoted {
+		cr := csv.NewReader(r)
+		cr.Comma = delim
+		cr.FieldsPerRecord = -1
+		cr.LazyQuotes = true
+		return cr.Read
+	}
+
+	br := bufio.NewReader(r)
+	sep := string(delim)
+	return func() ([]string, error) {
+		for {
+			line, err := br.ReadString('\n')
+			if line == "" && err != nil {
+				return nil, err
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if line == "" {
+				continue
+			}
+			return strings.Split(line, sep), nil
+		}
+	}
+}
+
+// gbifRow looks up the fields of a row by term name.
+type gbifRow struct {
+	index  map[string]int
+	fields []string
+}
+
+func (r gbifRow) get(term string) string {
+	i, ok := r.index[term]
+	if !ok || i >= len(r.fields) {
+		return ""
+	}
+	return strings.TrimSpace(r.fields[i])
+}
+
+func (r gbifRow) str(term string) *string {
+	if v := r.get(term); v != "" {
+		return &v
+	}
+	return nil
+}
+
+func (r gbifRow) int64(term string) *int64 {
+	n, err := strconv.ParseInt(r.get(term), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+func (r gbifRow) float(term string) *float64 {
+	f, err := strconv.ParseFloat(r.get(term), 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+// gbifRecord maps an occurrence row onto a record and derives the date
+// fields from its eventDate, or from its year, month and day when eventDate
+// holds no single day. ok is false when the row has no usable day.
+func gbifRecord(row gbifRow) (record MyMonarchRecord, ok bool) {
+	record = MyMonarchRecord{
+		GBIFID:                        row.str("gbifID"),
+		DatasetKey:                    row.str("datasetKey"),
+		PublishingOrgKey:              row.str("publishingOrgKey"),
+		EventDate:                     row.str("eventDate"),
+		ScientificName:                row.str("scientificName"),
+		VernacularName:                row.str("vernacularName"),
+		TaxonKey:                      row.int64("taxonKey"),
+		Kingdom:                       row.str("kingdom"),
+		Phylum:                        row.str("phylum"),
+		Class:                         row.str("class"),
+		Order:                         row.str("order"),
+		Family:                        row.str("family"),
+		Genus:                         row.str("genus"),
+		Species:                       row.str("species"),
+		DecimalLatitude:               row.float("decimalLatitude"),
+		DecimalLongitude:              row.float("decimalLongitude"),
+		CoordinateUncertaintyInMeters: row.float("coordinateUncertaintyInMeters"),
+		CountryCode:                   row.str("countryCode"),
+		StateProvince:                 row.str("stateProvince"),
+		IndividualCount:               row.int64("individualCount"),
+		BasisOfRecord:                 row.str("basisOfRecord"),
+		RecordedBy:                    row.str("recordedBy"),
+		OccurrenceID:                  row.str("occurrenceID"),
+		CollectionCode:                row.str("collectionCode"),
+		CatalogNumber:                 row.str("catalogNumber"),
+		County:                        row.str("county"),
+		CityOrTown:                    row.str("municipality"),
+	}
+
+	when, hasTime, ok := parseEventDate(row.get("eventDate"))
+	if !ok {
+		year, month, day := row.int64("year"), row.int64("month"), row.int64("day")
+		if year == nil || month == nil || day == nil {
+			return record, false
+		}
+		date, valid := newCalendarDate(int(*year), time.Month(*month), int(*day))
+		if !valid {
+			return record, false
+		}
+		when, hasTime = date.Time(), false
+	}
+	setDateFields(&record, when, hasTime)
+	return record, true
+}
+
+// setDateFields fills in the fields derived from the wall clock time of an
+// observation, numbering weekdays from Monday = 0 and weeks by ISO 8601 as
+// the daily tables do.
+func setDateFields(record *MyMonarchRecord, when time.Time, hasTime bool) {
+	year, month, day := when.Year(), int(when.Month()), when.Day()
+	weekday := (int(when.Weekday()) + 6) % 7
+	_, isoWeek := when.ISOWeek()
+	week := int64(isoWeek)
+	dateOnly := dateOf(when).String()
+
+	record.EventDateParsed = &when
+	record.Year, record.Month, record.Day = &year, &month, &day
+	record.DayOfWeek = &weekday
+	record.WeekOfYear = &week
+	record.DateOnly = &dateOnly
+	if hasTime {
+		timeOnly := when.Format("15:04:05")
+		record.TimeOnly = &timeOnly
+	}
+}
+
+// eventDateLayouts are the ISO 8601 forms of eventDate holding a day, with
+// a time first.
+var eventDateLayouts = []struct {
+	layout  string
+	hasTime bool
+}{
+	{time.RFC3339Nano, true},
+	{"2006-01-02T15:04:05.999999999", true},
+	{"2006-01-02T15:04Z07:00", true},
+	{"2006-01-02T15:04", true},
+	{"2006-01-02", false},
+}
+
+// parseEventDate reads the start of an eventDate, which may be a range such
+// as 2025-06-21/2025-06-22. The local wall clock time of the observation is
+// returned as UTC, since the tables store times without a zone.
+func parseEventDate(s string) (when time.Time, hasTime, ok bool) {
+	start, _, _ := strings.Cut(s, "/")
+	for _, l := range eventDateLayouts {
+		t, err := time.Parse(l.layout, start)
+		if err != nil {
+			continue
+		}
+		wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+		return wall, l.hasTime, true
+	}
+	return time.Time{}, false, false
+}
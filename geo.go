@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// earthRadiusKm is the mean Earth radius used for haversine distances.
+const earthRadiusKm = 6371.0088
+
+// metersPerDegreeLat is the length of one degree of latitude, used to widen
+// a bounding box by a coordinate uncertainty.
+const metersPerDegreeLat = 111320.0
+
+// BoundingBox selects sightings inside a longitude/latitude box. A box whose
+// MinLon is greater than its MaxLon crosses the antimeridian.
+type BoundingBox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+}
+
+// RadiusFilter selects sightings within RadiusKm of a point, measured along
+// the Earth's surface.
+type RadiusFilter struct {
+	Lat, Lon, RadiusKm float64
+}
+
+// parseBoundingBox parses "minLon,minLat,maxLon,maxLat".
+func parseBoundingBox(s string) (*BoundingBox, error) {
+	v, err := parseFloats(s, 4)
+	if err != nil {
+		return nil, fmt.Errorf("bbox must be minLon,minLat,maxLon,maxLat: %v", err)
+	}
+	b := &BoundingBox{MinLon: v[0], MinLat: v[1], MaxLon: v[2], MaxLat: v[3]}
+	if err := checkLon(b.MinLon); err != nil {
+		return nil, fmt.Errorf("bbox: %v", err)
+	}
+	if err := checkLon(b.MaxLon); err != nil {
+		return nil, fmt.Errorf("bbox: %v", err)
+	}
+	if err := checkLat(b.MinLat); err != nil {
+		return nil, fmt.Errorf("bbox: %v", err)
+	}
+	if err := checkLat(b.MaxLat); err != nil {
+		return nil, fmt.Errorf("bbox: %v", err)
+	}
+	if b.MinLat > b.MaxLat {
+		return nil, fmt.Errorf("bbox: minLat %g is above maxLat %g", b.MinLat, b.MaxLat)
+	}
+	return b, nil
+}
+
+// parseRadiusFilter parses near ("lat,lon") and radiusKm.
+func parseRadiusFilter(near, radius string) (*RadiusFilter, error) {
+	v, err := parseFloats(near, 2)
+	if err != nil {
+		return nil, fmt.Errorf("near must be lat,lon: %v", err)
+	}
+	if err := checkLat(v[0]); err != nil {
+		return nil, fmt.Errorf("near: %v", err)
+	}
+	if err := checkLon(v[1]); err != nil {
+		return nil, fmt.Errorf("near: %v", err)
+	}
+	if radius == "" {
+		return nil, fmt.Errorf("radiusKm is required with near")
+	}
+	r, err := strconv.ParseFloat(radius, 64)
+	if err != nil || r <= 0 || math.IsInf(r, 0) {
+		return nil, fmt.Errorf("radiusKm must be a positive number, got %q", radius)
+	}
+	return &RadiusFilter{Lat: v[0], Lon: v[1], RadiusKm: r}, nil
+}
+
+func parseFloats(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("expected %d comma-separated numbers, got %q", n, s)
+	}
+	v := make([]float64, n)
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || math.IsNaN(f) {
+			return nil, fmt.Errorf("%q is not a number", p)
+		}
+		v[i] = f
+	}
+	return v, nil
+}
+
+func checkLat(lat float64) error {
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("latitude %g is outside -90..90", lat)
+	}
+	return nil
+}
+
+func checkLon(lon float64) error {
+	if lon < -180 || lon > 180 {
+		return fmt.Errorf("longitude %g is outside -180..180", lon)
+	}
+	return nil
+}
+
+// haversine returns the haversine of the central angle between two points,
+// hav(θ) = sin²(θ/2). Comparing it against hav of the radius avoids the
+// asin and sqrt that SQL dialects handle differently.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := radians(lat2 - lat1)
+	dLon := radians(lon2 - lon1)
+	return math.Pow(math.Sin(dLat/2), 2) + math.Cos(radians(lat1))*math.Cos(radians(lat2))*math.Pow(math.Sin(dLon/2), 2)
+}
+
+// havOfDistance returns sin²(θ/2) for the central angle θ spanning km,
+// capped at half the globe where every point is in range.
+func havOfDistance(km float64) float64 {
+	return math.Pow(math.Sin(math.Min(km/earthRadiusKm, math.Pi)/2), 2)
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// uncertaintyKm returns a record's coordinate uncertainty in kilometers, or
+// zero when it is unknown.
+func uncertaintyKm(record MyMonarchRecord) float64 {
+	if record.CoordinateUncertaintyInMeters == nil {
+		return 0
+	}
+	return *record.CoordinateUncertaintyInMeters / 1000
+}
+
+// contains reports whether a record's position falls in the box. With
+// withUncertainty the box is widened by the record's coordinate uncertainty,
+// so a sighting counts when its uncertainty circle reaches the box.
+func (b BoundingBox) contains(record MyMonarchRecord, withUncertainty bool) bool {
+	if record.DecimalLatitude == nil || record.DecimalLongitude == nil {
+		return false
+	}
+	lat, lon := *record.DecimalLatitude, *record.DecimalLongitude
+
+	var latMargin, lonMargin float64
+	if withUncertainty && record.CoordinateUncertaintyInMeters != nil {
+		m := *record.CoordinateUncertaintyInMeters
+		latMargin = m / metersPerDegreeLat
+		lonMargin = m / (metersPerDegreeLat * math.Cos(radians(lat)))
+	}
+
+	if lat < b.MinLat-latMargin || lat > b.MaxLat+latMargin {
+		return false
+	}
+	if b.MinLon <= b.MaxLon {
+		return lon >= b.MinLon-lonMargin && lon <= b.MaxLon+lonMargin
+	}
+	return lon >= b.MinLon-lonMargin || lon <= b.MaxLon+lonMargin
+}
+
+// contains reports whether a record lies within the radius. With
+// withUncertainty the radius is extended by the record's coordinate
+// uncertainty.
+func (n RadiusFilter) contains(record MyMonarchRecord, withUncertainty bool) bool {
+	if record.DecimalLatitude == nil || record.DecimalLongitude == nil {
+		return false
+	}
+	limit := n.RadiusKm
+	if withUncertainty {
+		limit += uncertaintyKm(record)
+	}
+	return haversine(n.Lat, n.Lon, *record.DecimalLatitude, *record.DecimalLongitude) <= havOfDistance(limit)
+}
+
+// spatialColumns are the SQL expressions the spatial predicates read a
+// row's position and coordinate uncertainty from.
+type spatialColumns struct {
+	lat, lon, uncertainty string
+}
+
+// storedPosition reads the position as stored.
+var storedPosition = spatialColumns{
+	lat:         `"decimalLatitude"`,
+	lon:         `"decimalLongitude"`,
+	uncertainty: `"coordinateUncertaintyInMeters"`,
+}
+
+// publishedPosition reads the position accessPolicy.apply publishes for a
+// coordinate grid: the center of the row's cell, with the uncertainty
+// raised to cover the cell.
+func publishedPosition(grid float64) spatialColumns {
+	snap := func(column string) string {
+		return fmt.Sprintf(`(floor(%s / %g) * %g + %g)`, column, grid, grid, grid/2)
+	}
+	cell := gridCellMeters(grid)
+	return spatialColumns{
+		lat: snap(storedPosition.lat),
+		lon: snap(storedPosition.lon),
+		uncertainty: fmt.Sprintf(`(CASE WHEN %[1]s IS NULL OR %[1]s < %[2]g THEN %[3]g ELSE %[1]s END)`,
+			storedPosition.uncertainty, cell, math.Round(cell)),
+	}
+}
+
+// SQL forms of the spatial predicates. They use only functions Postgres and
+// SQLite share (sin, cos, radians, power, floor) and the same formulas as
+// contains. Every placeholder is used once, in argument order, since SQLite
+// binds "?" by position; haversine takes the point's latitude twice, then
+// its longitude.
+func (c spatialColumns) uncertaintyMeters() string {
+	return fmt.Sprintf(`COALESCE(%s, 0)`, c.uncertainty)
+}
+
+func (c spatialColumns) haversine() string {
+	return fmt.Sprintf(`power(sin(radians(%[1]s - %%s) / 2), 2) + cos(radians(%%s)) * cos(radians(%[1]s)) * power(sin(radians(%[2]s - %%s) / 2), 2)`, c.lat, c.lon)
+}
+
+// sqlConditions renders the box as SQL conditions on the position read
+// through cols, taking its bounds as bind arguments through add.
+func (b BoundingBox) sqlConditions(add func(expr string, args ...any), cols spatialColumns, withUncertainty bool) {
+	latMargin, lonMargin := "0", "0"
+	if withUncertainty {
+		latMargin = fmt.Sprintf(`%s / %.1f`, cols.uncertaintyMeters(), metersPerDegreeLat)
+		lonMargin = fmt.Sprintf(`%s / (%.1f * cos(radians(%s)))`, cols.uncertaintyMeters(), metersPerDegreeLat, cols.lat)
+	}
+
+	add(cols.lat+` >= %s - `+latMargin, b.MinLat)
+	add(cols.lat+` <= %s + `+latMargin, b.MaxLat)
+	if b.MinLon <= b.MaxLon {
+		add(cols.lon+` >= %s - `+lonMargin, b.MinLon)
+		add(cols.lon+` <= %s + `+lonMargin, b.MaxLon)
+		return
+	}
+	add(`(`+cols.lon+` >= %s - `+lonMargin+` OR `+cols.lon+` <= %s + `+lonMargin+`)`, b.MinLon, b.MaxLon)
+}
+
+// sqlConditions renders the radius check as a SQL condition on the position
+// read through cols.
+func (n RadiusFilter) sqlConditions(add func(expr string, args ...any), cols spatialColumns, withUncertainty bool) {
+	if !withUncertainty {
+		add(cols.haversine()+` <= %s`, n.Lat, n.Lat, n.Lon, havOfDistance(n.RadiusKm))
+		return
+	}
+	// The radius varies per row, so its haversine is computed in SQL,
+	// capped at half the globe like havOfDistance.
+	angle := fmt.Sprintf(`(%%s + %s / 1000.0) / %g`, cols.uncertaintyMeters(), earthRadiusKm)
+	add(cols.haversine()+` <= power(sin((CASE WHEN `+angle+` > pi() THEN pi() ELSE `+angle+` END) / 2), 2)`, n.Lat, n.Lat, n.Lon, n.RadiusKm, n.RadiusKm)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+)
+
+// distanceKm turns a haversine back into kilometers.
+func distanceKm(hav float64) float64 {
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(hav))
+}
+
+func TestHaversine(t *testing.T) {
+	degreeKm := 2 * math.Pi * earthRadiusKm / 360
+	tests := []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		want                   float64
+	}{
+		{"same point", 35.48, -97.57, 35.48, -97.57, 0},
+		{"one degree of latitude", 10, 20, 11, 20, degreeKm},
+		{"one degree of longitude at the equator", 0, 179.5, 0, -179.5, degreeKm},
+		{"one degree of longitude at 60°", 60, 0, 60, 1, 55.58},
+		{"antipodes", 0, 0, 0, 180, math.Pi * earthRadiusKm},
+		{"Oklahoma City to Toronto", 35.4676, -97.5164, 43.6532, -79.3832, 1796},
+	}
+	for _, tt := range tests {
+		got := distanceKm(haversine(tt.lat1, tt.lon1, tt.lat2, tt.lon2))
+		if math.Abs(got-tt.want) > 1 {
+			t.Errorf("%s: %.2f km, want %.2f", tt.name, got, tt.want)
+		}
+		if back := distanceKm(haversine(tt.lat2, tt.lon2, tt.lat1, tt.lon1)); math.Abs(back-got) > 1e-9 {
+			t.Errorf("%s: %.6f km one way and %.6f back", tt.name, got, back)
+		}
+	}
+	if h := havOfDistance(1e6); h != 1 {
+		t.Errorf("havOfDistance beyond the antipodes = %v, want 1", h)
+	}
+}
+
+func TestBoundingBoxContains(t *testing.T) {
+	at := func(lat, lon, uncertainty float64) MyMonarchRecord {
+		return MyMonarchRecord{DecimalLatitude: &lat, DecimalLongitude: &lon, CoordinateUncertaintyInMeters: &uncertainty}
+	}
+	box := BoundingBox{MinLon: -80, MinLat: 43, MaxLon: -79, MaxLat: 44}
+	dateline := BoundingBox{MinLon: 170, MinLat: -20, MaxLon: -170, MaxLat: -10}
+	tests := []struct {
+		name            string
+		box             BoundingBox
+		record          MyMonarchRecord
+		withUncertainty bool
+		want            bool
+	}{
+		{"inside", box, at(43.5, -79.4, 4), false, true},
+		{"on the edge", box, at(43, -80, 4), false, true},
+		{"north of the box", box, at(44.01, -79.4, 4), false, false},
+		{"east of the box", box, at(43.5, -78.99, 4), false, false},
+		{"reached by its uncertainty", box, at(44.01, -79.4, 5000), true, true},
+		{"uncertainty ignored", box, at(44.01, -79.4, 5000), false, false},
+		{"uncertainty too small", box, at(44.1, -79.4, 5000), true, false},
+		{"across the antimeridian, east", dateline, at(-15, 175, 0), false, true},
+		{"across the antimeridian, west", dateline, at(-15, -175, 0), false, true},
+		{"outside an antimeridian box", dateline, at(-15, 0, 0), false, false},
+		{"no coordinates", box, MyMonarchRecord{}, true, false},
+	}
+	for _, tt := range tests {
+		if got := tt.box.contains(tt.record, tt.withUncertainty); got != tt.want {
+			t.Errorf("%s: contains = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRadiusFilterContains(t *testing.T) {
+	lat, lon, far := 43.5, -79.4, 5000.0
+	record := MyMonarchRecord{DecimalLatitude: &lat, DecimalLongitude: &lon, CoordinateUncertaintyInMeters: &far}
+	// The sighting lies about 11.1 km north of the point.
+	near := RadiusFilter{Lat: 43.4, Lon: -79.4, RadiusKm: 10}
+	if near.contains(record, false) {
+		t.Error("matched beyond the radius")
+	}
+	if !near.contains(record, true) {
+		t.Error("uncertainty did not reach the radius")
+	}
+	near.RadiusKm = 11.2
+	if !near.contains(record, false) {
+		t.Error("missed a sighting within the radius")
+	}
+}
+
+func TestSpatialFilters(t *testing.T) {
+	records := append(fixtureRecords(t), sighting("1", -17.7, 177.4), sighting("2", -17.7, -177.4))
+	filters := map[string]SightingFilter{
+		"bbox":                     {BBox: &BoundingBox{MinLon: -80, MinLat: 43, MaxLon: -79, MaxLat: 44}},
+		"bbox with uncertainty":    {BBox: &BoundingBox{MinLon: -79.42, MinLat: 43.52, MaxLon: -79, MaxLat: 44}, IncludeUncertainty: true},
+		"near":                     {Near: &RadiusFilter{Lat: 35.45, Lon: -97.53, RadiusKm: 25}},
+		"near with uncertainty":    {Near: &RadiusFilter{Lat: 43.55, Lon: -79.41, RadiusKm: 1}, IncludeUncertainty: true},
+		"bbox across the dateline": {BBox: &BoundingBox{MinLon: 170, MinLat: -90, MaxLon: -170, MaxLat: 90}},
+	}
+	for name, store := range testStores(t, records) {
+		for fname, f := range filters {
+			t.Run(name+"/"+fname, func(t *testing.T) {
+				var want []string
+				for _, r := range records {
+					if (f.BBox == nil || f.BBox.contains(r, f.IncludeUncertainty)) && (f.Near == nil || f.Near.contains(r, f.IncludeUncertainty)) {
+						want = append(want, *r.GBIFID)
+					}
+				}
+				if len(want) == 0 || len(want) == len(records) {
+					t.Fatalf("the filter matches %d of %d records", len(want), len(records))
+				}
+				f.Start, f.End = fixtureStart, fixtureEnd
+				result, err := store.Filter(context.Background(), f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, r := range result.Records {
+					got = append(got, *r.GBIFID)
+				}
+				slices.Sort(got)
+				slices.Sort(want)
+				if !slices.Equal(got, want) {
+					t.Errorf("matched %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
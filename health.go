@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Build information, set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Unset values fall back to the VCS details Go embeds in the binary.
+var (
+	version   = "dev"
+	commit    string
+	buildTime string
+)
+
+// readyTimeout bounds the database ping of a readiness check.
+const readyTimeout = 2 * time.Second
+
+// versionResponse is the body of /version.
+type versionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"buildTime,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// buildVersion returns the ldflags build information, completed from
+// debug.ReadBuildInfo.
+func buildVersion() versionResponse {
+	v := versionResponse{Version: version, Commit: commit, BuildTime: buildTime, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	if v.Version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		v.Version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if v.Commit == "" {
+				v.Commit = setting.Value
+			}
+		case "vcs.time":
+			if v.BuildTime == "" {
+				v.BuildTime = setting.Value
+			}
+		case "vcs.modified":
+			v.Modified = setting.Value == "true"
+		}
+	}
+	return v
+}
+
+// checkStatus is the outcome of one readiness check.
+type checkStatus struct {
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	LatencyMs   *int64     `json:"latencyMs,omitempty"`
+	RefreshedAt *time.Time `json:"refreshedAt,omitempty"`
+}
+
+// readyResponse is the body of /readyz.
+type readyResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkStatus `json:"checks"`
+}
+
+// getHealthz reports that the process is up. It checks nothing else, so an
+// orchestrator only restarts the server when it stops answering.
+func getHealthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// getReadyz reports whether the server can answer sightings requests: the
+// database must answer a ping and the table catalog must have loaded. It
+// returns 503 with the failing checks otherwise.
+func (s *server) getReadyz(w http.ResponseWriter, r *http.Request) {
+	resp := readyResponse{Status: "ready", Checks: map[string]checkStatus{}}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	started := time.Now()
+	err := s.store.Ping(ctx)
+	latency := time.Since(started).Milliseconds()
+	database := checkStatus{Status: "ok", LatencyMs: &latency}
+	if err != nil {
+		// The endpoint is public, so driver messages stay in the log.
+		slog.ErrorContext(ctx, "readiness check: database ping failed", "error", err)
+		database.Status, database.Error = "error", "unavailable"
+	}
+	resp.Checks["database"] = database
+
+	catalog := checkStatus{Status: "ok"}
+	if refreshedAt := s.catalog.RefreshedAt(); !refreshedAt.IsZero() {
+		catalog.RefreshedAt = &refreshedAt
+	} else {
+		catalog.Status, catalog.Error = "error", "table catalog has not loaded yet"
+	}
+	resp.Checks["catalog"] = catalog
+
+	status := http.StatusOK
+	for _, check := range resp.Checks {
+		if check.Status != "ok" {
+			resp.Status, status = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getVersion reports what build is running.
+func getVersion(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildVersion())
+}
@@ -0,0 +1,683 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// importStagingTable receives the COPY of a download before its rows are
+// merged into the daily or partitioned tables.
+const importStagingTable = "gbif_import"
+
+// importProgressEvery is how many rows pass between progress log lines.
+const importProgressEvery = 100000
+
+// importLockID is the Postgres advisory lock held by an import, and by each
+// table consolidate copies, for its whole transaction. Both check for a
+// record key before inserting it, so two running at once would both insert
+// a record new to either.
+const importLockID = 7042026
+
+// runImport implements "monarchbutterfly import gbif FILE". The download is
+// streamed into a temporary staging table with COPY and then merged into
+// the tables of the store's layout, all in one transaction, so a failed
+// import leaves nothing behind and a repeated one changes nothing.
+func runImport(args []string) error {
+	if len(args) == 0 || args[0] != "gbif" {
+		return errors.New(`usage: monarchbutterfly import gbif [flags] FILE`)
+	}
+	flags := flag.NewFlagSet("import gbif", flag.ExitOnError)
+	configPath := flags.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	layoutFlag := flags.String("layout", "", "daily or partitioned; defaults to the layout the server reads")
+	dryRun := flags.Bool("dry-run", false, "read and map the download without writing anything")
+	markMissing := flags.Bool("mark-missing", false, "flag previously imported records of the download's datasets and date range that it no longer contains; only for complete downloads")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monarchbutterfly import gbif [flags] FILE")
+		fmt.Fprintln(flags.Output(), "FILE is a GBIF occurrence download: a Darwin Core Archive or simple CSV zip, or an unzipped occurrence file.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args[1:])
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected one download file")
+	}
+	file := flags.Arg(0)
+	if *layoutFlag != "" && *layoutFlag != layoutDaily && *layoutFlag != layoutPartitioned {
+		return fmt.Errorf("-layout must be daily or partitioned, got %q", *layoutFlag)
+	}
+
+	cfg := initConfig(*configPath)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	src, err := openGBIFSource(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if *dryRun {
+		stats, err := readGBIF(ctx, src, func(MyMonarchRecord) error { return nil })
+		if err != nil {
+			return err
+		}
+		stats.log(ctx, "dry run finished", file)
+		return nil
+	}
+
+	if cfg.Store.Kind != "postgres" {
+		return fmt.Errorf("import needs the postgres store, got %q", cfg.Store.Kind)
+	}
+	naming, err := newTableNaming(cfg.Tables.DailyFormat)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := checkSchema(ctx, db); err != nil {
+		return err
+	}
+	layout := *layoutFlag
+	if layout == "" {
+		if layout, err = resolveLayout(ctx, db, cfg.Tables.Layout); err != nil {
+			return err
+		}
+	}
+
+	imp := &gbifImport{
+		db:          db,
+		naming:      naming,
+		layout:      layout,
+		source:      "gbif:" + filepath.Base(file),
+		runAt:       time.Now().UTC(),
+		markMissing: *markMissing,
+	}
+	return imp.run(ctx, src)
+}
+
+// importStats counts the rows of a download as they are read and keyed.
+type importStats struct {
+	Rows    int64
+	Skipped int64
+	// Unkeyed rows have neither a gbifID nor an occurrenceID and
+	// datasetKey, so they cannot be merged; Duplicates repeat a key later
+	// in the download. Both are counted once the rows are staged.
+	Unkeyed    int64
+	Duplicates int64
+	// FirstDate and LastDate bound the days of the rows read, and once the
+	// rows are keyed, of the rows that will be merged.
+	FirstDate string
+	LastDate  string
+}
+
+func (s importStats) log(ctx context.Context, msg, source string) {
+	slog.InfoContext(ctx, msg, "source", source, "rows", s.Rows, "skipped", s.Skipped, "first_date", s.FirstDate, "last_date", s.LastDate)
+}
+
+// dateRange returns the first and last day of the download.
+func (s importStats) dateRange() (first, last calendarDate, err error) {
+	f, err := time.Parse("2006-01-02", s.FirstDate)
+	if err != nil {
+		return first, last, err
+	}
+	l, err := time.Parse("2006-01-02", s.LastDate)
+	if err != nil {
+		return first, last, err
+	}
+	return dateOf(f), dateOf(l), nil
+}
+
+// readGBIF maps every row of src onto a record and hands it to fn. Rows
+// without a usable day are counted as skipped.
+func readGBIF(ctx context.Context, src *gbifSource, fn func(MyMonarchRecord) error) (importStats, error) {
+	index := make(map[string]int, len(src.columns))
+	for i, name := range src.columns {
+		if _, dup := index[name]; !dup && name != "" {
+			index[name] = i
+		}
+	}
+	if _, ok := index["eventDate"]; !ok {
+		return importStats{}, errors.New("the download has no eventDate column")
+	}
+
+	var stats importStats
+	for line := 1; ; line++ {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		fields, err := src.Next()
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, fmt.Errorf("row %d: %w", line, err)
+		}
+
+		record, ok := gbifRecord(gbifRow{index: index, fields: fields})
+		if !ok {
+			stats.Skipped++
+			slog.DebugContext(ctx, "skipping row without a usable day", "row", line, "gbif_id", deref(record.GBIFID), "event_date", deref(record.EventDate))
+			continue
+		}
+		if err := fn(record); err != nil {
+			return stats, err
+		}
+
+		stats.Rows++
+		if day := *record.DateOnly; stats.FirstDate == "" || day < stats.FirstDate {
+			stats.FirstDate = day
+		}
+		if day := *record.DateOnly; day > stats.LastDate {
+			stats.LastDate = day
+		}
+		if stats.Rows%importProgressEvery == 0 {
+			slog.InfoContext(ctx, "import progress", "rows", stats.Rows, "skipped", stats.Skipped)
+		}
+	}
+}
+
+// gbifImport loads one download into a Postgres database.
+type gbifImport struct {
+	db     *sql.DB
+	naming tableNaming
+	layout string
+	// source is recorded in source_table for rows loaded into the
+	// partitioned table.
+	source string
+	// runAt is recorded in first_seen_at, last_seen_at and missing_since.
+	runAt time.Time
+	// markMissing flags previously imported records of the download's
+	// datasets and date range that it no longer contains.
+	markMissing bool
+}
+
+func (imp *gbifImport) run(ctx context.Context, src *gbifSource) error {
+	start := time.Now()
+	tx, err := imp.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, importLockID); err != nil {
+		return fmt.Errorf("failed to lock the sightings tables: %w", err)
+	}
+
+	stats, err := imp.stage(ctx, tx, src)
+	if err != nil {
+		return err
+	}
+	if err := imp.keyStaged(ctx, tx, &stats); err != nil {
+		return err
+	}
+	if stats.Rows == 0 {
+		stats.log(ctx, "nothing to import", imp.source)
+		return nil
+	}
+
+	var counts mergeResult
+	if imp.layout == layoutPartitioned {
+		counts, err = imp.loadPartitioned(ctx, tx, stats)
+	} else {
+		counts, err = imp.loadDaily(ctx, tx, stats)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "import finished", "source", imp.source, "layout", imp.layout,
+		"rows", stats.Rows, "skipped", stats.Skipped, "unkeyed", stats.Unkeyed, "duplicates", stats.Duplicates,
+		"inserted", counts.Inserted, "updated", counts.Updated, "unchanged", counts.Unchanged, "disappeared", counts.Disappeared,
+		"first_date", stats.FirstDate, "last_date", stats.LastDate, "duration", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// stage creates the staging table and copies the download into it.
+func (imp *gbifImport) stage(ctx context.Context, tx *sql.Tx, src *gbifSource) (importStats, error) {
+	create := fmt.Sprintf(`CREATE TEMP TABLE "%s" (%s, "record_key" TEXT, "first_seen_at" TIMESTAMPTZ) ON COMMIT DROP`, importStagingTable, columnDefinitions())
+	if _, err := tx.ExecContext(ctx, create); err != nil {
+		return importStats{}, newStoreError(stageQuery, importStagingTable, err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(importStagingTable, sightingColumnNames()...))
+	if err != nil {
+		return importStats{}, newStoreError(stageQuery, importStagingTable, err)
+	}
+	defer stmt.Close()
+
+	stats, err := readGBIF(ctx, src, func(record MyMonarchRecord) error {
+		_, err := stmt.ExecContext(ctx, recordValues(record)...)
+		return err
+	})
+	if err != nil {
+		return stats, err
+	}
+	// An Exec without arguments flushes the COPY.
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return stats, newStoreError(stageQuery, importStagingTable, err)
+	}
+	return stats, nil
+}
+
+// keyStaged gives each staged row its record key, then drops the rows
+// without one and all but the last row of each key, so every key is merged
+// once.
+func (imp *gbifImport) keyStaged(ctx context.Context, tx *sql.Tx, stats *importStats) error {
+	steps := []struct {
+		query string
+		count *int64
+	}{
+		{fmt.Sprintf(`UPDATE "%s" SET "record_key" = %s`, importStagingTable, recordKeyExpr), nil},
+		{fmt.Sprintf(`DELETE FROM "%s" WHERE "record_key" IS NULL`, importStagingTable), &stats.Unkeyed},
+		{fmt.Sprintf(`DELETE FROM "%[1]s" a USING "%[1]s" b WHERE a."record_key" = b."record_key" AND a.ctid < b.ctid`, importStagingTable), &stats.Duplicates},
+	}
+	for _, step := range steps {
+		res, err := tx.ExecContext(ctx, step.query)
+		if err != nil {
+			return newStoreError(stageQuery, importStagingTable, err)
+		}
+		if step.count != nil {
+			*step.count, _ = res.RowsAffected()
+			stats.Rows -= *step.count
+		}
+	}
+	if stats.Unkeyed > 0 {
+		slog.WarnContext(ctx, "skipping rows without a gbifID or occurrenceID and datasetKey", "rows", stats.Unkeyed)
+	}
+
+	// The dropped rows no longer count towards the download's days.
+	var first, last sql.NullString
+	query := fmt.Sprintf(`SELECT CAST(MIN("date_only") AS TEXT), CAST(MAX("date_only") AS TEXT) FROM "%s"`, importStagingTable)
+	if err := tx.QueryRowContext(ctx, query).Scan(&first, &last); err != nil {
+		return newStoreError(stageQuery, importStagingTable, err)
+	}
+	stats.FirstDate, stats.LastDate = first.String, last.String
+	return nil
+}
+
+// loadPartitioned merges the staged rows into the partitioned table.
+// Records whose day changed since they were last imported are moved to
+// their new partition and counted as updated.
+func (imp *gbifImport) loadPartitioned(ctx context.Context, tx *sql.Tx, stats importStats) (mergeResult, error) {
+	first, last, err := stats.dateRange()
+	if err != nil {
+		return mergeResult{}, err
+	}
+	if err := ensurePartitions(ctx, tx, first, last); err != nil {
+		return mergeResult{}, err
+	}
+
+	// Moving a record deletes it from its old partition; its first_seen_at
+	// rides along in the staging table.
+	move := fmt.Sprintf(`WITH moved AS (
+		DELETE FROM "sightings" t USING "%[1]s" g
+		WHERE t."record_key" = g."record_key" AND t."date_only" <> g."date_only"
+		RETURNING t."record_key", t."first_seen_at"
+	)
+	UPDATE "%[1]s" g SET "first_seen_at" = moved."first_seen_at" FROM moved WHERE g."record_key" = moved."record_key"`, importStagingTable)
+	res, err := tx.ExecContext(ctx, move)
+	if err != nil {
+		return mergeResult{}, newStoreError(stageQuery, "sightings", err)
+	}
+	moved, _ := res.RowsAffected()
+
+	counts, err := imp.merge(ctx, tx, mergeTarget{table: "sightings", source: imp.source})
+	if err != nil {
+		return mergeResult{}, err
+	}
+	counts.Inserted -= moved
+	counts.Updated += moved
+
+	if imp.markMissing {
+		counts.Disappeared, err = markMissing(ctx, tx, "sightings", imp.runAt, `"date_only" BETWEEN $2 AND $3 AND `+missingScope, first.String(), last.String())
+		if err != nil {
+			return mergeResult{}, err
+		}
+	}
+	return counts, nil
+}
+
+// loadDaily merges the staged rows of each day into its daily table,
+// creating the table when the day is new and adding the tracking columns
+// to tables that predate them. Records whose day changed within the
+// download's range are moved out of their old day's table first and
+// counted as updated.
+func (imp *gbifImport) loadDaily(ctx context.Context, tx *sql.Tx, stats importStats) (mergeResult, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT DISTINCT CAST("date_only" AS TEXT) FROM "%s" ORDER BY 1`, importStagingTable))
+	if err != nil {
+		return mergeResult{}, newStoreError(stageQuery, importStagingTable, err)
+	}
+	var days []string
+	for rows.Next() {
+		var day string
+		if err := rows.Scan(&day); err != nil {
+			rows.Close()
+			return mergeResult{}, newStoreError(stageScan, importStagingTable, err)
+		}
+		days = append(days, day)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return mergeResult{}, newStoreError(stageIterate, importStagingTable, err)
+	}
+
+	moved, err := imp.moveDaily(ctx, tx, stats)
+	if err != nil {
+		return mergeResult{}, err
+	}
+
+	var total mergeResult
+	staged := make(map[string]bool, len(days))
+	for _, day := range days {
+		t, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			return mergeResult{}, err
+		}
+		table := imp.naming.name(dateOf(t))
+		staged[table] = true
+		if err := ensureTracked(ctx, tx, table); err != nil {
+			return mergeResult{}, err
+		}
+
+		counts, err := imp.merge(ctx, tx, mergeTarget{table: table, day: day})
+		if err != nil {
+			return mergeResult{}, err
+		}
+		if imp.markMissing {
+			counts.Disappeared, err = markMissing(ctx, tx, table, imp.runAt, missingScope)
+			if err != nil {
+				return mergeResult{}, err
+			}
+		}
+		slog.InfoContext(ctx, "merged daily table", "table", table, "inserted", counts.Inserted, "updated", counts.Updated, "unchanged", counts.Unchanged, "disappeared", counts.Disappeared)
+		total.add(counts)
+	}
+	total.Inserted -= moved
+	total.Updated += moved
+	if !imp.markMissing {
+		return total, nil
+	}
+
+	// Every record of a tracked day in the download's range that has no
+	// rows in the download has disappeared too.
+	first, last, err := stats.dateRange()
+	if err != nil {
+		return mergeResult{}, err
+	}
+	tracked, err := trackedTables(ctx, tx)
+	if err != nil {
+		return mergeResult{}, err
+	}
+	for _, table := range tracked {
+		day, ok := imp.naming.parse(table)
+		if !ok || staged[table] || day.Before(first.Time()) || day.After(last.Time()) {
+			continue
+		}
+		n, err := markMissing(ctx, tx, table, imp.runAt, missingScope)
+		if err != nil {
+			return mergeResult{}, err
+		}
+		total.Disappeared += n
+	}
+	return total, nil
+}
+
+// moveDaily deletes the staged records from the tracked daily tables in
+// the download's range that hold them under another day, carrying their
+// first_seen_at into the staging table, and returns how many it moved.
+func (imp *gbifImport) moveDaily(ctx context.Context, tx *sql.Tx, stats importStats) (int64, error) {
+	first, last, err := stats.dateRange()
+	if err != nil {
+		return 0, err
+	}
+	tracked, err := trackedTables(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	var moved int64
+	for _, table := range tracked {
+		day, ok := imp.naming.parse(table)
+		if !ok || day.Before(first.Time()) || day.After(last.Time()) {
+			continue
+		}
+		move := fmt.Sprintf(`WITH moved AS (
+			DELETE FROM "%[2]s" t USING "%[1]s" g
+			WHERE t."record_key" = g."record_key" AND g."date_only" <> $1
+			RETURNING t."record_key", t."first_seen_at"
+		)
+		UPDATE "%[1]s" g SET "first_seen_at" = moved."first_seen_at" FROM moved WHERE g."record_key" = moved."record_key"`, importStagingTable, table)
+		res, err := tx.ExecContext(ctx, move, day.Format("2006-01-02"))
+		if err != nil {
+			return 0, newStoreError(stageQuery, table, err)
+		}
+		n, _ := res.RowsAffected()
+		if n > 0 {
+			slog.InfoContext(ctx, "moved records out of daily table", "table", table, "records", n)
+		}
+		moved += n
+	}
+	return moved, nil
+}
+
+// mergeResult counts what a merge did to the records of a download.
+type mergeResult struct {
+	Inserted  int64
+	Updated   int64
+	Unchanged int64
+	// Disappeared counts records of the download's days that it no longer
+	// contains and that were marked missing.
+	Disappeared int64
+}
+
+func (r *mergeResult) add(o mergeResult) {
+	r.Inserted += o.Inserted
+	r.Updated += o.Updated
+	r.Unchanged += o.Unchanged
+	r.Disappeared += o.Disappeared
+}
+
+// mergeTarget is a table staged rows are merged into: a daily table, which
+// takes the staged rows of its day, or the partitioned table, which takes
+// all of them and records source in source_table.
+type mergeTarget struct {
+	table  string
+	day    string
+	source string
+}
+
+// merge upserts the staged rows into target by record key. Records whose
+// values changed are updated, the rest only have last_seen_at moved
+// forward, and new ones are inserted; any of them that had been marked
+// missing are present again. Values are cast to the target's column types,
+// since daily tables written by other tools may not use the types of
+// sightingColumns, and columns the target lacks are left out.
+func (imp *gbifImport) merge(ctx context.Context, tx *sql.Tx, target mergeTarget) (mergeResult, error) {
+	types, err := columnTypes(ctx, tx, target.table)
+	if err != nil {
+		return mergeResult{}, err
+	}
+	var names, values, current, assign []string
+	for _, c := range sightingColumns {
+		typ, ok := types[c.name]
+		if !ok {
+			continue
+		}
+		value := fmt.Sprintf(`CAST(g."%s" AS %s)`, c.name, typ)
+		names = append(names, fmt.Sprintf(`"%s"`, c.name))
+		values = append(values, value)
+		current = append(current, fmt.Sprintf(`t."%s"`, c.name))
+		assign = append(assign, fmt.Sprintf(`"%s" = %s`, c.name, value))
+	}
+
+	// $1 is the time of the run; $2 the day of a daily table.
+	args := []any{imp.runAt}
+	filter := "TRUE"
+	if target.day != "" {
+		args = append(args, target.day)
+		filter = `g."date_only" = $2`
+	}
+	// The partitioned table also takes the source, after the other
+	// arguments, in the statements writing rows.
+	writeArgs := args
+	if target.source != "" {
+		writeArgs = append(slices.Clip(args), target.source)
+		names = append(names, `"source_table"`)
+		values = append(values, fmt.Sprintf("$%d", len(writeArgs)))
+		assign = append(assign, fmt.Sprintf(`"source_table" = $%d`, len(writeArgs)))
+	}
+
+	var result mergeResult
+	steps := []struct {
+		query string
+		args  []any
+		count *int64
+	}{
+		{fmt.Sprintf(`UPDATE "%s" t SET %s, "last_seen_at" = $1, "missing_since" = NULL FROM "%s" g
+			WHERE t."record_key" = g."record_key" AND %s AND (%s) IS DISTINCT FROM (%s)`,
+			target.table, strings.Join(assign, ", "), importStagingTable, filter, strings.Join(current, ", "), strings.Join(values[:len(current)], ", ")),
+			writeArgs, &result.Updated},
+		{fmt.Sprintf(`UPDATE "%s" t SET "last_seen_at" = $1, "missing_since" = NULL FROM "%s" g
+			WHERE t."record_key" = g."record_key" AND %s AND t."last_seen_at" IS DISTINCT FROM $1`,
+			target.table, importStagingTable, filter),
+			args, &result.Unchanged},
+		{fmt.Sprintf(`INSERT INTO "%s" (%s, "record_key", "first_seen_at", "last_seen_at")
+			SELECT %s, g."record_key", COALESCE(g."first_seen_at", $1), $1 FROM "%s" g
+			WHERE %s AND NOT EXISTS (SELECT 1 FROM "%s" t WHERE t."record_key" = g."record_key")`,
+			target.table, strings.Join(names, ", "), strings.Join(values, ", "), importStagingTable, filter, target.table),
+			writeArgs, &result.Inserted},
+	}
+	for _, step := range steps {
+		res, err := tx.ExecContext(ctx, step.query, step.args...)
+		if err != nil {
+			return mergeResult{}, newStoreError(stageQuery, target.table, err)
+		}
+		*step.count, _ = res.RowsAffected()
+	}
+	return result, nil
+}
+
+// missingScope limits markMissing to the datasets of the download, so a
+// download of one dataset leaves the records of the others alone. Filters
+// it cannot see, such as a taxon or country, are not narrowed further,
+// which is why marking is only for complete downloads.
+var missingScope = fmt.Sprintf(`CAST("datasetKey" AS TEXT) IN (SELECT CAST(g."datasetKey" AS TEXT) FROM "%s" g)`, importStagingTable)
+
+// markMissing sets missing_since on the records of table within scope that
+// an earlier import saw and this one did not. Records no import has seen,
+// such as rows copied in by consolidate, are left alone. scope may use $2
+// onwards for args.
+func markMissing(ctx context.Context, tx *sql.Tx, table string, runAt time.Time, scope string, args ...any) (int64, error) {
+	query := fmt.Sprintf(`UPDATE "%s" SET "missing_since" = $1 WHERE "last_seen_at" < $1 AND "missing_since" IS NULL AND %s`, table, scope)
+	res, err := tx.ExecContext(ctx, query, append([]any{runAt}, args...)...)
+	if err != nil {
+		return 0, newStoreError(stageQuery, table, err)
+	}
+	return res.RowsAffected()
+}
+
+// ensureTracked creates a daily table with the tracking columns, or adds
+// them to one that predates them and keys its existing rows. A unique index
+// keeps each record key to one row; rows repeating a key, which only copies
+// of the same record can do, are dropped before it is built.
+func ensureTracked(ctx context.Context, tx *sql.Tx, table string) error {
+	defs := make([]string, len(trackingColumns))
+	for i, c := range trackingColumns {
+		defs[i] = fmt.Sprintf(`ADD COLUMN IF NOT EXISTS "%s" %s`, c.name, c.pgType)
+	}
+	for _, query := range []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (%s)`, table, columnDefinitions()),
+		fmt.Sprintf(`ALTER TABLE "%s" %s`, table, strings.Join(defs, ", ")),
+		fmt.Sprintf(`UPDATE "%s" SET "record_key" = %s WHERE "record_key" IS NULL`, table, recordKeyExpr),
+		fmt.Sprintf(`DELETE FROM "%[1]s" a USING "%[1]s" b WHERE a."record_key" = b."record_key" AND a.ctid < b.ctid`, table),
+		fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS "%[1]s_record_key" ON "%[1]s" ("record_key")`, table),
+	} {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return newStoreError(stageQuery, table, err)
+		}
+	}
+	return nil
+}
+
+// trackedTables returns the tables in the current schema that have the
+// tracking columns.
+func trackedTables(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT table_name FROM information_schema.columns WHERE table_schema = current_schema() AND column_name = 'last_seen_at' ORDER BY 1`)
+	if err != nil {
+		return nil, newStoreError(stageQuery, "information_schema.columns", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, newStoreError(stageScan, "information_schema.columns", err)
+		}
+		tables = append(tables, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newStoreError(stageIterate, "information_schema.columns", err)
+	}
+	return tables, nil
+}
+
+// columnTypes returns the types of the columns of table, as Postgres spells
+// them in a CAST.
+func columnTypes(ctx context.Context, tx *sql.Tx, table string) (map[string]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT attname, format_type(atttypid, atttypmod) FROM pg_attribute
+		WHERE attrelid = to_regclass(quote_ident($1)) AND attnum > 0 AND NOT attisdropped`, table)
+	if err != nil {
+		return nil, newStoreError(stageQuery, table, err)
+	}
+	defer rows.Close()
+
+	types := make(map[string]string)
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, newStoreError(stageScan, table, err)
+		}
+		types[name] = typ
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newStoreError(stageIterate, table, err)
+	}
+	return types, nil
+}
+
+// sightingColumnNames returns the names of sightingColumns.
+func sightingColumnNames() []string {
+	names := make([]string, len(sightingColumns))
+	for i, c := range sightingColumns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// columnDefinitions returns sightingColumns as the column list of a CREATE
+// TABLE, matching the daily tables.
+func columnDefinitions() string {
+	defs := make([]string, len(sightingColumns))
+	for i, c := range sightingColumns {
+		defs[i] = fmt.Sprintf(`"%s" %s`, c.name, c.pgType)
+	}
+	return strings.Join(defs, ", ")
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// logConfig selects the level and encoding of the server's logs.
+type logConfig struct {
+	// Level is debug, info, warn or error.
+	Level string `yaml:"level"`
+	// Format is json or text.
+	Format string `yaml:"format"`
+}
+
+// newLogger builds the logger described by cfg, writing to w. Records
+// logged with a request context carry its request ID.
+func newLogger(cfg logConfig, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", cfg.Level)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch cfg.Format {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want json or text)", cfg.Format)
+	}
+	return slog.New(requestIDHandler{h}), nil
+}
+
+// requestIDHandler adds the request ID of the context passed to the
+// *Context logging calls, so handlers need not thread a logger through.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if id, ok := ctx.Value(contextKeyRequestID).(string); ok {
+		rec.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// contextKeyRequestID carries the ID of the current request.
+const contextKeyRequestID contextKey = "requestID"
+
+// maxRequestIDLength bounds incoming X-Request-ID values, which are copied
+// into every log line of the request.
+const maxRequestIDLength = 128
+
+// requestID returns the caller's X-Request-ID when it is usable, or a new
+// random ID.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= maxRequestIDLength && printableASCII(id) {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func printableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// accessEntry collects what the access log line reports about a request as
+// it passes through the router.
+type accessEntry struct {
+	route string
+}
+
+// contextKeyAccessEntry carries the *accessEntry of the current request.
+const contextKeyAccessEntry contextKey = "accessEntry"
+
+// requestLogger assigns every request an ID, echoed in the X-Request-ID
+// response header and attached to its log lines, and writes one access log
+// line per request. It wraps the whole handler chain, so requests answered
+// by CORS or unmatched by the router are logged too.
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		id := requestID(r)
+		w.Header().Set("X-Request-ID", id)
+
+		entry := &accessEntry{}
+		usage := &rowUsage{}
+		ctx := context.WithValue(r.Context(), contextKeyRequestID, id)
+		ctx = context.WithValue(ctx, contextKeyAccessEntry, entry)
+		ctx = context.WithValue(ctx, contextKeyRowUsage, usage)
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(ctx, level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", entry.route),
+			slog.Int("status", rec.status),
+			slog.Float64("duration_ms", float64(time.Since(started).Microseconds())/1000),
+			slog.Int64("bytes", rec.bytes),
+			slog.Int64("rows", usage.rows),
+			slog.String("remote", r.RemoteAddr),
+		)
+	})
+}
+
+// recordRoute notes the matched route template for the access log. It is
+// the router's first middleware.
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if entry, ok := r.Context().Value(contextKeyAccessEntry).(*accessEntry); ok {
+			entry.route = routeTemplate(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeTemplate returns the path template of the route mux matched for r,
+// or "" outside the router.
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return template
+}
@@ -3,13 +3,17 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 	"time"
 
 	// The `pq` package is a pure Go PostgreSQL driver for `database/sql`.
@@ -72,15 +76,17 @@ type MyMonarchRecord struct {
 	TimeOnly                 *string    `json:"time_only"` // Storing as string to handle "time without time zone"
 }
 
-var db *sql.DB
-// var descopeClient *client.DescopeClient
-
-// var isAnAdmin bool
-// // Define a custom key type to avoid collisions
-// type contextKey string
+// server carries the dependencies shared by the HTTP handlers.
+type server struct {
+	store   SightingStore
+	catalog *tableCatalog
+	keys    APIKeyStore
+	// streaming allows stream=true on the sightings routes.
+	streaming bool
+	// writeTimeout is the server's write timeout, renewed as streams flush.
+	writeTimeout time.Duration
+}
 
-// const contextKeyUserID contextKey = "userID"
-// const contextKeyTeacherID contextKey = "teacherID" // A key for the teacher ID
 
 
 // getMonarchsHandler fetches all Monarch butterfly data from the database
@@ -195,7 +201,7 @@ func faviconHandler(w http.ResponseWriter, r *http.Request) {
     // Open the favicon file
     favicon, err := os.ReadFile("./static/butterfly_net.ico")
     if err != nil {
-        http.NotFound(w, r)
+        notFoundHandler(w, r)
         return
     }
 
@@ -206,66 +212,107 @@ func faviconHandler(w http.ResponseWriter, r *http.Request) {
     w.Write(favicon)
 }
 
- // sessionValidationMiddleware is a middleware to validate the Descope session token.
-// func sessionValidationMiddleware(next http.Handler) http.Handler {
-// 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-// 		sessionToken := r.Header.Get("Authorization")
-// 		if sessionToken == "" {
-// 			http.Error(w, "Unauthorized: No session token provided", http.StatusUnauthorized)
-// 			return
-// 		}
+func main() {
+	// Maintenance commands run instead of the server.
+	if len(os.Args) > 1 {
+		commands := map[string]func([]string) error{
+			"migrate":     runMigrate,
+			"consolidate": runConsolidate,
+			"import":      runImport,
+		}
+		if run, ok := commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fatal(os.Args[1]+" failed", err)
+			}
+			return
+		}
+	}
 
-// 		sessionToken = strings.TrimPrefix(sessionToken, "Bearer ")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	flag.Parse()
+	cfg := initConfig(*configPath)
 
-// 		ctx := r.Context()
-// 		authorized, token, err := descopeClient.Auth.ValidateSessionWithToken(ctx, sessionToken)
-// 		if err != nil || !authorized {
-// 			log.Printf("Session validation failed: %v", err)
-// 			http.Error(w, "Unauthorized: Invalid session token", http.StatusUnauthorized)
-// 			return
-// 		}
-// 		if descopeClient.Auth.ValidateRoles(context.Background(), token, []string{"Game Admin"}) {
-// 			isAnAdmin = true
-// 		} else {
-// 			isAnAdmin = false
-// 		}
+	// SIGINT and SIGTERM start a graceful shutdown.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-// 		userID := token.ID
-// 		// userRole := token.GetTenants()
-// 		// userRole := token.GetTenantValue()
-// 		// userRole := token.GetTenants()
-// 		if userID == "" {
-// 			http.Error(w, "Unauthorized: User ID not found in token", http.StatusUnauthorized)
-// 			return
-// 		}
+	// Open the sightings store once; every handler shares it.
+	store, err := openStore(cfg)
+	if err != nil {
+		fatal("failed to open sightings store", err)
+	}
 
-// 		// For this example, we assume the player ID is the same as the user ID.
-// 		// In a real-world app, you would extract this from custom claims in the token.
-// 		playerID := userID
+	// Load the table catalog before serving and keep it fresh in the background.
+	catalog := newTableCatalog(store)
+	if err := catalog.Refresh(ctx); err != nil {
+		slog.Error("initial catalog refresh failed", "error", err)
+	}
+	go catalog.Run(ctx, cfg.Catalog.RefreshInterval)
 
-// 		// Store the user ID and teacher ID in the request's context
-// 		ctxWithUserID := context.WithValue(ctx, contextKeyUserID, userID)
-// 		ctxWithIDs := context.WithValue(ctxWithUserID, contextKeyPlayerID, playerID)
+	srv := &server{store: store, catalog: catalog, streaming: cfg.Features.Streaming, writeTimeout: cfg.Server.WriteTimeout}
+	var authenticator *apiKeyAuthenticator
+	if cfg.Features.APIKeys {
+		srv.keys, err = apiKeyStoreFor(store)
+		if err != nil {
+			fatal("failed to open API key store", err)
+		}
+		authenticator = &apiKeyAuthenticator{store: srv.keys}
+	}
 
-// 		next.ServeHTTP(w, r.WithContext(ctxWithIDs))
-// 	})
-// }
-func main() {
 	// Set up the HTTP router.
 		// Initialize the router
 	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+
+	// Credentials are checked on every route so callers are rate limited by
+	// identity rather than by IP whenever they present one.
+	validator, err := newTokenValidator(cfg.Auth)
+	if err != nil {
+		fatal("failed to set up session validation", err)
+	}
+	if validator == nil {
+		slog.Warn("neither auth.descopeProjectID nor auth.staticTokens is set; bearer tokens are rejected")
+	}
+	router.Use(recordRoute)
+	router.Use(metricsMiddleware)
+	router.Use(sessionValidationMiddleware(validator, authenticator))
+	router.Use(requireScope)
+	router.Use(newRateLimiter(cfg.RateLimit).middleware)
 
 	router.HandleFunc("/", helloHandler)
 	router.HandleFunc("/favicon.ico", faviconHandler)
-	// Protected routes (require session validation)
-	// protectedRoutes := router.PathPrefix("/api").Subrouter()
-	// protectedRoutes.Use(sessionValidationMiddleware) // Apply middleware to all routes in this subrouter
-
-	// protectedRoutes.HandleFunc("/monarchbutterlies/dayscan/{calendarDate}", getSingleDayScan).Methods("GET")
-	// protectedRoutes.HandleFunc("/monarchsjune2025", getAllMonarchs).Methods("GET")
+	router.HandleFunc("/healthz", getHealthz).Methods("GET")
+	router.HandleFunc("/readyz", srv.getReadyz).Methods("GET")
+	router.HandleFunc("/version", getVersion).Methods("GET")
+	if cfg.Features.Metrics {
+		router.Handle("/metrics", newMetricsHandler(store)).Methods("GET")
+	}
+	// Protected routes (require a session token or API key)
+	protectedRoutes := router.PathPrefix("/api").Subrouter()
+	protectedRoutes.Use(requireSession) // Apply middleware to all routes in this subrouter
+
+	protectedRoutes.HandleFunc("/session", getSession).Methods("GET")
+	protectedRoutes.HandleFunc("/monarchbutterlies/dayscan/{calendarDate}", srv.getSingleDayScan).Methods("GET")
+	protectedRoutes.HandleFunc("/monarchsjune2025", srv.getAllMonarchs).Methods("GET")
+	protectedRoutes.HandleFunc("/monarchbutterlies/range", srv.getRangeScan).Methods("GET")
+	protectedRoutes.HandleFunc("/stats/counts", srv.getStatsCounts).Methods("GET")
+
+	if cfg.Features.APIKeys {
+		adminRoutes := protectedRoutes.PathPrefix("/admin").Subrouter()
+		adminRoutes.Use(requireRole(adminRole))
+		adminRoutes.HandleFunc("/keys", srv.listAPIKeys).Methods("GET")
+		adminRoutes.HandleFunc("/keys", srv.createAPIKey).Methods("POST")
+		adminRoutes.HandleFunc("/keys/{id}", srv.revokeAPIKey).Methods("DELETE")
+	}
 
-	router.HandleFunc("/monarchbutterlies/dayscan/{calendarDate}", getSingleDayScan).Methods("GET")
-	router.HandleFunc("/monarchsjune2025", getAllMonarchs).Methods("GET")
+	if cfg.Features.PublicRoutes {
+		router.HandleFunc("/monarchbutterlies/dayscan/{calendarDate}", srv.getSingleDayScan).Methods("GET")
+		router.HandleFunc("/monarchsjune2025", srv.getAllMonarchs).Methods("GET")
+		router.HandleFunc("/monarchbutterlies/range", srv.getRangeScan).Methods("GET")
+		router.HandleFunc("/stats/counts", srv.getStatsCounts).Methods("GET")
+	}
+	router.HandleFunc("/catalog", srv.getCatalog).Methods("GET")
 
 
 	// router.HandleFunc("/june212025", getMonarchsHandler)
@@ -273,171 +320,133 @@ func main() {
 
 	
 	// --- CORS Setup ---
-	allowedOrigins := handlers.AllowedOrigins([]string{"*"})
-	allowedMethods := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"})
-	allowedHeaders := handlers.AllowedHeaders([]string{"Content-Type", "Authorization"})
+	allowedOrigins := handlers.AllowedOrigins(cfg.CORS.AllowedOrigins)
+	allowedMethods := handlers.AllowedMethods(cfg.CORS.AllowedMethods)
+	allowedHeaders := handlers.AllowedHeaders(cfg.CORS.AllowedHeaders)
 	corsRouter := handlers.CORS(allowedOrigins, allowedMethods, allowedHeaders)(router)
 
 	// // Start the server on port 5000.
 	// fmt.Println("Server is running on port 5000...")
 	// log.Fatal(http.ListenAndServe(":5000", nil))
 	
-	// Start the HTTP server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080" // Default port
+	// Start the HTTP server and run until it fails or is signalled to stop.
+	httpServer := newHTTPServer(cfg.Server, requestLogger(corsRouter))
+	serveErr := serve(ctx, httpServer, cfg.Server.TLS, cfg.Server.ShutdownTimeout)
+
+	// Requests have finished or been cancelled, so the pool can close.
+	if err := store.Close(); err != nil {
+		slog.Error("failed to close sightings store", "error", err)
+	}
+	if serveErr != nil {
+		fatal("server failed", serveErr)
 	}
-	fmt.Printf("Server listening on port %s...\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, corsRouter))
+	slog.Info("server stopped")
 }
 
-func getAllMonarchsAsAdmin2(w http.ResponseWriter, _ *http.Request) {
-	var monarchButterflies []MyMonarchRecord
-	query := `SELECT * FROM "2025_M06_JUN_2025_butterflies_CT" ORDER BY "date_only"`
-	rows, err := db.Query(query)
-
+// initConfig loads the configuration and installs its logger as the default,
+// exiting if either fails.
+func initConfig(path string) Config {
+	cfg, err := loadConfig(path)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error retrieving butterflies: %v", err), http.StatusInternalServerError)
-		return
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var monarchButterfly MyMonarchRecord
-		err := rows.Scan(&monarchButterfly.DateOnly, &monarchButterfly.TimeOnly, &monarchButterfly.CityOrTown, &monarchButterfly.County, &monarchButterfly.StateProvince)
-		if err != nil {
-			log.Printf("Error scanning monarch butterfly row: %v", err)
-			continue
-		}
-		monarchButterflies = append(monarchButterflies, monarchButterfly)
+	logger, err := newLogger(cfg.Log, os.Stderr)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
 	}
+	slog.SetDefault(logger)
+	return cfg
+}
 
-	if err = rows.Err(); err != nil {
-		http.Error(w, fmt.Sprintf("Error iterating over monarch butterfly rows: %v", err), http.StatusInternalServerError)
+// fatal logs err and exits. Deferred calls do not run.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// getAllMonarchsAsAdmin2 serves the legacy June 2025 table, as the caller's
+// access policy allows.
+func (s *server) getAllMonarchsAsAdmin2(w http.ResponseWriter, r *http.Request) {
+	monarchButterflies, err := s.store.LegacySightings(r.Context())
+	if err != nil {
+		writeStoreError(w, r, err)
 		return
 	}
+	if left, ok := rowAllowance(r.Context()); ok && int64(len(monarchButterflies)) > left {
+		writeProblem(w, r, http.StatusTooManyRequests, codeQuotaExhausted, fmt.Sprintf("The table has %d rows but only %d are left of the daily row quota", len(monarchButterflies), left))
+		return
+	}
+	policy := policyFor(r.Context())
+	for i, record := range monarchButterflies {
+		monarchButterflies[i] = policy.apply(record)
+	}
+	countRows(r.Context(), len(monarchButterflies))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(monarchButterflies)
 }
 
-// CHQ: Gemini AI corrected function
-// Corrected getAllMonarchsAsAdmin to ignore the 'r' parameter
-// func getMonarchButterfliesSingleDayAsAdmin(theTablename string, w http.ResponseWriter, r *http.Request) {
-
-func getMonarchButterfliesSingleDayAsAdmin(theTablename string, w http.ResponseWriter, _ *http.Request) {
-	// 1. Establish DB Connection
-	connStr := os.Getenv("DIG_OCEAN_DROPLET_DOCKER_PSQL")
-	db, err := sql.Open("postgres", connStr)
+// getMonarchButterfliesSingleDayAsAdmin serves the sightings recorded on day
+// that match the request's field filters, optionally paged with limit/cursor,
+// streamed with stream=true or rendered as GeoJSON, CSV or TSV.
+func (s *server) getMonarchButterfliesSingleDayAsAdmin(date calendarDate, w http.ResponseWriter, r *http.Request) {
+	filter, err := parseSightingFilter(r)
 	if err != nil {
-		log.Printf("Failed to connect to database: %v", err) // Log 1: Connection failure
-		http.Error(w, fmt.Sprintf("Failed to connect to database: %v", err), http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidParameter, err.Error())
 		return
 	}
-	defer db.Close()
+	day := date.Time()
+	filter.Start, filter.End = day, day
 
-	// 2. Ping DB
-	err = db.Ping()
+	page, err := parsePageParams(r)
 	if err != nil {
-		log.Printf("Database ping failed: %v", err) // Log 2: Ping failure
-		http.Error(w, fmt.Sprintf("Database ping failed: %v", err), http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidParameter, err.Error())
 		return
 	}
-	
-	var monarchButterflies []MyMonarchRecord
-	tableName := theTablename
-	
-	// Explicitly listing all 35 columns to match the struct fields.
-	query := fmt.Sprintf(`SELECT "gbifID", "datasetKey", "publishingOrgKey", "eventDate", "eventDateParsed", "year", "month", "day", "day_of_week", "week_of_year", "date_only", "scientificName", "vernacularName", "taxonKey", "kingdom", "phylum", "class", "order", "family", "genus", "species", "decimalLatitude", "decimalLongitude", "coordinateUncertaintyInMeters", "countryCode", "stateProvince", "individualCount", "basisOfRecord", "recordedBy", "occurrenceID", "collectionCode", "catalogNumber", "county", "cityOrTown", "time_only" FROM "%s" ORDER BY "date_only"`, tableName)
-	
-	// 3. Execute Query
-	rows, err := db.Query(query)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error retrieving butterflies: %v", err), http.StatusInternalServerError)
-		log.Printf("Query failed for table %s: %v", tableName, err) // Log 3: Query failure
+
+	s.serveSightings(w, r, filter, page, responseMeta{
+		SingleDay: true,
+		Start:     date.String(),
+		End:       date.String(),
+	})
+}
+
+// writeStoreError logs a failed store call with its internal detail and
+// reports it to the client as a problem whose message names no tables or
+// driver errors.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	countStoreError(err)
+	ctx := r.Context()
+	if errors.Is(err, errInvalidRange) {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidDate, err.Error())
 		return
 	}
-	defer rows.Close()
-
-	// 4. Iterate and Scan Rows
-	for rows.Next() {
-		var record MyMonarchRecord
-		err := rows.Scan(
-			&record.GBIFID,
-			&record.DatasetKey,
-			&record.PublishingOrgKey,
-			&record.EventDate,
-			&record.EventDateParsed,
-			&record.Year,
-			&record.Month,
-			&record.Day,
-			&record.DayOfWeek,
-			&record.WeekOfYear,
-			&record.DateOnly,
-			&record.ScientificName,
-			&record.VernacularName,
-			&record.TaxonKey,
-			&record.Kingdom,
-			&record.Phylum,
-			&record.Class,
-			&record.Order,
-			&record.Family,
-			&record.Genus,
-			&record.Species,
-			&record.DecimalLatitude,
-			&record.DecimalLongitude,
-			&record.CoordinateUncertaintyInMeters,
-			&record.CountryCode,
-			&record.StateProvince,
-			&record.IndividualCount,
-			&record.BasisOfRecord,
-			&record.RecordedBy,
-			&record.OccurrenceID,
-			&record.CollectionCode,
-			&record.CatalogNumber,
-			&record.County,
-			&record.CityOrTown,
-			&record.TimeOnly,
-		)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to scan row: %v", err), http.StatusInternalServerError)
-			log.Printf("Failed to scan row from table %s: %v", tableName, err) // Log 4: Scan failure
-			return
-		}
-		monarchButterflies = append(monarchButterflies, record)
-	}
-
-	// 5. Check for Row Iteration Errors
-	if err = rows.Err(); err != nil {
-		http.Error(w, fmt.Sprintf("Error iterating over monarch butterfly rows: %v", err), http.StatusInternalServerError)
-		log.Printf("Error iterating over rows from table %s: %v", tableName, err) // Log 5: Row iteration error
+	if isUndefinedTable(err) {
+		slog.WarnContext(ctx, "table not found", "error", err)
+		writeProblem(w, r, http.StatusNotFound, codeTableNotFound, "No sightings recorded for the requested date")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(monarchButterflies)
-}
-
-// Corrected getAllMonarchsAsAdmin to ignore the 'r' parameter
-func getAllMonarchsAsAdmin(w http.ResponseWriter, _ *http.Request) {
-	connStr := os.Getenv("DIG_OCEAN_DROPLET_DOCKER_PSQL")
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to connect to database: %v", err), http.StatusInternalServerError)
-		log.Printf("Failed to connect to database: %v", err)
+	var se *storeError
+	if !errors.As(err, &se) {
+		slog.ErrorContext(ctx, "store call failed", "error", err)
+		writeProblem(w, r, http.StatusInternalServerError, codeInternal, "Error retrieving butterflies")
 		return
 	}
-	defer db.Close()
 
-	err = db.Ping()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Database ping failed: %v", err), http.StatusInternalServerError)
-		log.Printf("Database ping failed: %v", err)
+	switch se.Stage {
+	case stageConnect:
+		slog.ErrorContext(ctx, "failed to connect to database", "stage", se.Stage, "error", se.Err)
+		writeProblem(w, r, http.StatusServiceUnavailable, codeDBUnavailable, "The database is unavailable, retry later")
 		return
+	case stageScan:
+		slog.ErrorContext(ctx, "failed to scan row", "stage", se.Stage, "table", se.Table, "error", se.Err)
+	case stageIterate:
+		slog.ErrorContext(ctx, "failed iterating over rows", "stage", se.Stage, "table", se.Table, "error", se.Err)
+	default:
+		slog.ErrorContext(ctx, "query failed", "stage", se.Stage, "table", se.Table, "error", se.Err)
 	}
-
-	// getMonarchButterfliesSingleDayAsAdmin("june212025", w, nil)
+	writeProblem(w, r, http.StatusInternalServerError, codeDBError, "Error retrieving butterflies")
 }
 
 // getAllgodbstudents handles GET requests to retrieve all student records for the authenticated teacher.
@@ -478,132 +487,41 @@ func getAllMonarchsAsAdmin(w http.ResponseWriter, _ *http.Request) {
 // }
 
 // CHQ: Gemini AI corrected parameters to ignore the r
-func getAllMonarchs(w http.ResponseWriter, _ *http.Request) {
-	// if (isAnAdmin) {
-		getAllMonarchsAsAdmin2(w, nil) // You can pass nil as the request since the function doesn't use it
-
-    // getAllMonarchsAsAdmin(w, nil) // You can pass nil as the request since the function doesn't use it
-	// } else {
-		// getAllMonarchsAsTeacher(w, r)
-	// }
-}
-
-func generateTableName(day int, monthInt int, year int) string {
-	// 1. Define the equivalent of my_calendar (a map in Go)
-	// You would typically define this map globally or pass it in,
-	// but defining it here works for a direct conversion.
-
-	monthIntToStr := ""
-
-	if(monthInt < 10){
-		monthIntToStr = ("0" + strconv.Itoa(monthInt))
-	} else {
-		monthIntToStr = strconv.Itoa(monthInt)
-	}
-  
-	myCalendar := map[string]string{
-		"01":   "january",
-		"02":   "february",
-		"03":   "march",
-		"04":   "april",
-		"05":   "may",
-		"06":   "june",
-		"07":   "july",
-		"08":    "august",
-		"09": "september",
-		"10":   "october",
-		"11":  "november",
-		"12":  "december",
-	}
-
-	// Retrieve the month string from the map
-	// monthStr, ok := myCalendar[month]
-	monthStr, ok := myCalendar[monthIntToStr]
- 
-	if !ok {
-		// Handle case where the month is not found (optional, but good practice)
-		return "Error: Invalid month"
-	}
-
-	// 2. Implement the conditional logic and string concatenation
-	var tableName string
-	yearStr := strconv.Itoa(year) // Convert int year to string
-
-	tableName = fmt.Sprintf("%s%d%s", monthStr, day, yearStr)
-
-	return tableName
+// getAllMonarchs serves the legacy June 2025 table. What each caller sees is
+// decided by policyFor rather than by separate admin and public handlers.
+func (s *server) getAllMonarchs(w http.ResponseWriter, r *http.Request) {
+	s.getAllMonarchsAsAdmin2(w, r)
 }
 
 // CHQ: Gemini AI added log statements to debug
-func getSingleDayScan(w http.ResponseWriter, r *http.Request) {
+func (s *server) getSingleDayScan(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
-	// Get the date as a string (MMDDYYYY)
+	// Get the date as a string (MMDDYYYY, YYYY-MM-DD, today, -7d, ...)
 	dateStr := vars["calendarDate"]
 
 	// **********************************************
 	// DEBUG LOGGING ADDED HERE TO SEE THE RECEIVED DATE STRING AND LENGTH
 	// **********************************************
-	log.Printf("Received calendarDate: %s (Length: %d)", dateStr, len(dateStr))
-
-	// 1. String length check (must be exactly 8 characters)
-	if len(dateStr) != 8 {
-		http.Error(w, "Invalid date given - expected 8 digits in MMDDYYYY format", http.StatusBadRequest)
-		log.Printf("Invalid date string length: %s, expected 8", dateStr)
-		return
-	}
-
-	// 2. Extract components using string slicing (MMDDYYYY)
-	monthStr := dateStr[0:2] // MM (e.g., "06")
-	dayStr := dateStr[2:4]   // DD (e.g., "30")
-	yearStr := dateStr[4:8]  // YYYY (e.g., "2025")
+	slog.DebugContext(r.Context(), "received calendarDate", "calendar_date", dateStr, "length", len(dateStr))
 
-	// 3. Convert day and year to integers for table name generation
-	dayInt, err := strconv.Atoi(dayStr)
+	// Parse and validate the date; impossible dates like 02312025 stop here
+	date, err := parseDate(dateStr, time.Now())
 	if err != nil {
-		http.Error(w, "Invalid day format in date", http.StatusBadRequest)
-		log.Printf("Invalid day format: %s", dayStr)
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidDate, fmt.Sprintf("Invalid date given: %v", err))
+		slog.DebugContext(r.Context(), "invalid date", "calendar_date", dateStr, "error", err)
 		return
 	}
 
-	yearInt, err := strconv.Atoi(yearStr)
-	if err != nil {
-		http.Error(w, "Invalid year format in date", http.StatusBadRequest)
-		log.Printf("Invalid year format: %s", yearStr)
-		return
-	}
-	
-	// Check if monthStr is a valid two-digit number
-	// Although we use monthStr in generateTableName, we need to ensure it's a number
-	if _, err := strconv.Atoi(monthStr); err != nil {
-		http.Error(w, "Invalid month format in date: not a number", http.StatusBadRequest)
-		log.Printf("Invalid month format: %s", monthStr)
+	// Days the catalog has no table for are a clean 404 rather than a database error
+	if !s.catalog.HasDate(date) {
+		writeProblem(w, r, http.StatusNotFound, codeTableNotFound, fmt.Sprintf("No sightings recorded for %s", date))
+		slog.DebugContext(r.Context(), "no table in catalog", "date", date.String())
 		return
 	}
 
-	monthInt, err := strconv.Atoi(monthStr)
-	if err != nil {
-		http.Error(w, "Invalid month format in date", http.StatusBadRequest)
-		log.Printf("Invalid month format: %s", monthStr)
-		return	
-	}
-	
-	// The `useVariable` flag is preserved from your original code
-	useVariable := false 
-
-	// Generate the dynamic table name using the string-based month
-	myChoice := generateTableName(dayInt, monthInt, yearInt)
-
-	// If useVariable is false, override with the hardcoded test table name
-	if useVariable {
-		myChoice = "june212025"
-		log.Printf("Using hardcoded table name: %s", myChoice)
-	} else {
-		log.Printf("Using generated table name: %s", myChoice)
-	}
-
-	// Call the function to fetch data from the determined table
-	getMonarchButterfliesSingleDayAsAdmin(myChoice, w, nil)
+	// Call the function to fetch data for the requested day
+	s.getMonarchButterfliesSingleDayAsAdmin(date, w, r)
 }
 
 
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics served on /metrics. Requests are labelled with the mux
+// route template rather than the path, so day scans for different dates
+// share one series.
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "monarch_http_requests_total",
+		Help: "HTTP requests by route template, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "monarch_http_request_duration_seconds",
+		Help:    "HTTP request latency by route template and method.",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"route", "method"})
+
+	httpResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "monarch_http_response_size_bytes",
+		Help:    "HTTP response body size by route template.",
+		Buckets: prometheus.ExponentialBuckets(256, 4, 10),
+	}, []string{"route"})
+
+	dbRowsScanned = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "monarch_db_rows_scanned",
+		Help:    "Rows scanned per database query, by kind of query (select or aggregate).",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 11),
+	}, []string{"query"})
+
+	storeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "monarch_store_errors_total",
+		Help: "Failed store calls by stage (connect, query, scan, iterate).",
+	}, []string{"stage"})
+)
+
+// newMetricsHandler registers the metrics, the Go runtime and process
+// collectors and, for SQL stores, the connection pool statistics, and
+// returns the /metrics handler.
+func newMetricsHandler(store SightingStore) http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		httpRequests, httpDuration, httpResponseSize, dbRowsScanned, storeErrors,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if db := storeDB(store); db != nil {
+		reg.MustRegister(collectors.NewDBStatsCollector(db, "sightings"))
+	}
+	// Every stage is exported from the start, so alerts on connect
+	// failures have a series to watch before the first one happens.
+	for _, stage := range []string{stageConnect, stageQuery, stageScan, stageIterate} {
+		storeErrors.WithLabelValues(stage)
+	}
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
+
+// storeDB returns the connection pool of a SQL-backed store, or nil.
+func storeDB(store SightingStore) *sql.DB {
+	switch s := store.(type) {
+	case *postgresStore:
+		return s.db
+	case *partitionedStore:
+		return s.db
+	case *sqliteStore:
+		return s.db
+	default:
+		return nil
+	}
+}
+
+// countStoreError records a failed store call under its stage. Errors that
+// are not storeErrors, such as invalid ranges, are not failures of the store.
+func countStoreError(err error) {
+	var se *storeError
+	if errors.As(err, &se) {
+		storeErrors.WithLabelValues(se.Stage).Inc()
+	}
+}
+
+// metricsMiddleware records the count, latency and response size of every
+// routed request. It runs right after recordRoute, ahead of authentication
+// and rate limiting, so rejected requests are counted too.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+
+		started := time.Now()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(started).Seconds())
+		httpResponseSize.WithLabelValues(route).Observe(float64(rec.bytes))
+	})
+}
+
+// responseRecorder captures the status and body size of a response. It keeps
+// flushing and http.ResponseController working for streamed responses.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *responseRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
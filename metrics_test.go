@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"database/sql/driver"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrapeValue returns the value of series in a recorded /metrics response.
+func scrapeValue(t *testing.T, rec *httptest.ResponseRecorder, series string) float64 {
+	t.Helper()
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), series+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+	}
+	t.Fatalf("no series %s", series)
+	return 0
+}
+
+func TestStoreErrorMetricStages(t *testing.T) {
+	handler := newMetricsHandler(newMemoryStore(nil))
+	scrape := func(series string) float64 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return scrapeValue(t, rec, series)
+	}
+	const connect, query = `monarch_store_errors_total{stage="connect"}`, `monarch_store_errors_total{stage="query"}`
+
+	beforeConnect, beforeQuery := scrape(connect), scrape(query)
+	countStoreError(newStoreError(stageQuery, "june212025", driver.ErrBadConn))
+	if got := scrape(connect) - beforeConnect; got != 1 {
+		t.Errorf("connect errors rose by %v, want 1", got)
+	}
+	if got := scrape(query) - beforeQuery; got != 0 {
+		t.Errorf("query errors rose by %v, want 0", got)
+	}
+}
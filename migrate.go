@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// migrationFiles holds the schema migrations of each SQL store, named
+// migrations/<store>/NNNN_name.up.sql with a matching .down.sql.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// migration is one versioned schema change.
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// loadMigrations reads the embedded migrations of a store kind, ordered by
+// version. Every migration must have both an up and a down file.
+func loadMigrations(kind string) ([]migration, error) {
+	dir := path.Join("migrations", kind)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for the %s store", kind)
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		m := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration file %s/%s is not named NNNN_name.up.sql or NNNN_name.down.sql", dir, entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// migrationTableSchema creates the table recording applied migrations, per
+// store kind.
+var migrationTableSchema = map[string]string{
+	"postgres": `CREATE TABLE IF NOT EXISTS "schema_migrations" (
+	"version" INTEGER PRIMARY KEY,
+	"name" TEXT NOT NULL,
+	"applied_at" TIMESTAMPTZ NOT NULL
+)`,
+	"sqlite": `CREATE TABLE IF NOT EXISTS "schema_migrations" (
+	"version" INTEGER PRIMARY KEY,
+	"name" TEXT NOT NULL,
+	"applied_at" DATETIME NOT NULL
+)`,
+}
+
+// migrationLockID is the Postgres advisory lock held while a migration runs,
+// so servers starting together do not apply the same one twice.
+const migrationLockID = 7042025
+
+// migrator applies the embedded migrations of one store kind to db. Each
+// migration runs in its own transaction together with its schema_migrations
+// row, so a failed migration leaves no trace.
+type migrator struct {
+	db          *sql.DB
+	kind        string
+	placeholder func(int) string
+	migrations  []migration
+}
+
+func newMigrator(db *sql.DB, kind string) (*migrator, error) {
+	migrations, err := loadMigrations(kind)
+	if err != nil {
+		return nil, err
+	}
+	placeholder := sqlitePlaceholder
+	if kind == "postgres" {
+		placeholder = pgPlaceholder
+	}
+	return &migrator{db: db, kind: kind, placeholder: placeholder, migrations: migrations}, nil
+}
+
+// latest returns the version the embedded migrations bring the schema to.
+func (m *migrator) latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// ensureTable creates schema_migrations if it does not exist yet.
+func (m *migrator) ensureTable(ctx context.Context) error {
+	if _, err := m.db.ExecContext(ctx, migrationTableSchema[m.kind]); err != nil {
+		return newStoreError(stageQuery, "schema_migrations", err)
+	}
+	return nil
+}
+
+// applied returns the versions recorded in schema_migrations. It only reads
+// the table, which must exist.
+func (m *migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT "version", "applied_at" FROM "schema_migrations"`)
+	if err != nil {
+		return nil, newStoreError(stageQuery, "schema_migrations", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, newStoreError(stageScan, "schema_migrations", err)
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newStoreError(stageIterate, "schema_migrations", err)
+	}
+	return applied, nil
+}
+
+// Up applies every pending migration up to and including target, in order.
+func (m *migrator) Up(ctx context.Context, target int) error {
+	if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+	for _, mig := range m.migrations {
+		if mig.Version > target {
+			break
+		}
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		if err := m.run(ctx, mig, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down reverts the applied migrations above target, newest first.
+func (m *migrator) Down(ctx context.Context, target int) error {
+	if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if mig.Version <= target {
+			break
+		}
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		if err := m.run(ctx, mig, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run applies (up) or reverts (down) one migration and records it.
+func (m *migrator) run(ctx context.Context, mig migration, up bool) error {
+	direction, body := "up", mig.Up
+	if !up {
+		direction, body = "down", mig.Down
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if m.kind == "postgres" {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+			return fmt.Errorf("failed to lock schema_migrations: %w", err)
+		}
+		// Another process may have run it while we waited for the lock.
+		var done bool
+		query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "schema_migrations" WHERE "version" = %s)`, m.placeholder(1))
+		if err := tx.QueryRowContext(ctx, query, mig.Version).Scan(&done); err != nil {
+			return err
+		}
+		if done == up {
+			return nil
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return fmt.Errorf("migration %04d_%s %s failed: %w", mig.Version, mig.Name, direction, err)
+	}
+	if up {
+		query := fmt.Sprintf(`INSERT INTO "schema_migrations" ("version", "name", "applied_at") VALUES (%s, %s, %s)`, m.placeholder(1), m.placeholder(2), m.placeholder(3))
+		_, err = tx.ExecContext(ctx, query, mig.Version, mig.Name, time.Now().UTC())
+	} else {
+		query := fmt.Sprintf(`DELETE FROM "schema_migrations" WHERE "version" = %s`, m.placeholder(1))
+		_, err = tx.ExecContext(ctx, query, mig.Version)
+	}
+	if err != nil {
+		return newStoreError(stageQuery, "schema_migrations", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "migration "+direction, "store", m.kind, "version", mig.Version, "name", mig.Name)
+	return nil
+}
+
+// errSchemaBehind is returned by Check when migrations are pending.
+var errSchemaBehind = errors.New("database schema is behind")
+
+// Check verifies that every embedded migration has been applied. The server
+// refuses to start otherwise. A schema ahead of this build, as after rolling
+// back a deploy, only warns. Check only reads the database: a missing
+// schema_migrations table means no migration has run.
+func (m *migrator) Check(ctx context.Context) error {
+	applied, err := m.applied(ctx)
+	if isUndefinedTable(err) {
+		return fmt.Errorf("%w: schema_migrations table is missing; run \"monarchbutterfly migrate up\"", errSchemaBehind)
+	}
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, fmt.Sprintf("%04d_%s", mig.Version, mig.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: pending migrations %v; run \"monarchbutterfly migrate up\"", errSchemaBehind, pending)
+	}
+	for version := range applied {
+		if version > m.latest() {
+			slog.WarnContext(ctx, "database schema is newer than this build", "version", version, "latest_known", m.latest())
+			break
+		}
+	}
+	return nil
+}
+
+// checkSchema refuses a Postgres database with pending migrations.
+func checkSchema(ctx context.Context, db *sql.DB) error {
+	m, err := newMigrator(db, "postgres")
+	if err != nil {
+		return err
+	}
+	return m.Check(ctx)
+}
+
+// runMigrate implements "monarchbutterfly migrate up|down|status [version]".
+// up applies every pending migration, or those up to version; down reverts
+// the newest applied migration, or every one above version; status lists
+// each migration and when it was applied.
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configPath := flags.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monarchbutterfly migrate [-config file] up|down|status [version]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		return errors.New("expected a command and an optional version")
+	}
+	command := flags.Arg(0)
+	if command != "up" && command != "down" && command != "status" {
+		flags.Usage()
+		return fmt.Errorf("unknown migrate command %q", command)
+	}
+	target := -1
+	if flags.NArg() == 2 {
+		v, err := strconv.Atoi(flags.Arg(1))
+		if err != nil || v < 0 {
+			return fmt.Errorf("version must be a non-negative number, got %q", flags.Arg(1))
+		}
+		target = v
+	}
+
+	cfg := initConfig(*configPath)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := openSchemaDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	m, err := newMigrator(db, cfg.Store.Kind)
+	if err != nil {
+		return err
+	}
+
+	if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+	switch command {
+	case "up":
+		if target < 0 {
+			target = m.latest()
+		}
+		return m.Up(ctx, target)
+	case "down":
+		if target < 0 {
+			applied, err := m.applied(ctx)
+			if err != nil {
+				return err
+			}
+			target = previousVersion(applied)
+		}
+		return m.Down(ctx, target)
+	case "status":
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if at, ok := applied[mig.Version]; ok {
+				fmt.Printf("%04d_%s\tapplied %s\n", mig.Version, mig.Name, at.UTC().Format(time.RFC3339))
+			} else {
+				fmt.Printf("%04d_%s\tpending\n", mig.Version, mig.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// previousVersion returns the newest applied version below the newest one,
+// the target that reverts a single migration.
+func previousVersion(applied map[int]time.Time) int {
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	if len(versions) < 2 {
+		return 0
+	}
+	return versions[len(versions)-2]
+}
+
+// openSchemaDB opens the database of a SQL store for the maintenance
+// commands.
+func openSchemaDB(cfg Config) (*sql.DB, error) {
+	switch cfg.Store.Kind {
+	case "postgres":
+		return openDB(cfg.Database)
+	case "sqlite":
+		return openSQLiteDB(cfg.Store.SQLitePath)
+	default:
+		return nil, fmt.Errorf("the %s store has no schema to migrate", cfg.Store.Kind)
+	}
+}
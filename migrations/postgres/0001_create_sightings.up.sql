@@ -0,0 +1,46 @@
+-- The unified sightings table: the 35 columns of MyMonarchRecord, plus the
+-- table each row was consolidated from. Monthly partitions are created as
+-- data arrives; there is no default partition.
+CREATE TABLE IF NOT EXISTS "sightings" (
+	"gbifID" TEXT,
+	"datasetKey" TEXT,
+	"publishingOrgKey" TEXT,
+	"eventDate" TEXT,
+	"eventDateParsed" TIMESTAMP,
+	"year" INTEGER,
+	"month" INTEGER,
+	"day" INTEGER,
+	"day_of_week" INTEGER,
+	"week_of_year" BIGINT,
+	"date_only" DATE NOT NULL,
+	"scientificName" TEXT,
+	"vernacularName" TEXT,
+	"taxonKey" BIGINT,
+	"kingdom" TEXT,
+	"phylum" TEXT,
+	"class" TEXT,
+	"order" TEXT,
+	"family" TEXT,
+	"genus" TEXT,
+	"species" TEXT,
+	"decimalLatitude" DOUBLE PRECISION,
+	"decimalLongitude" DOUBLE PRECISION,
+	"coordinateUncertaintyInMeters" DOUBLE PRECISION,
+	"countryCode" TEXT,
+	"stateProvince" TEXT,
+	"individualCount" BIGINT,
+	"basisOfRecord" TEXT,
+	"recordedBy" TEXT,
+	"occurrenceID" TEXT,
+	"collectionCode" TEXT,
+	"catalogNumber" TEXT,
+	"county" TEXT,
+	"cityOrTown" TEXT,
+	"time_only" TIME,
+	"source_table" TEXT NOT NULL
+) PARTITION BY RANGE ("date_only");
+
+CREATE INDEX IF NOT EXISTS "sightings_date_only" ON "sightings" ("date_only", "time_only", "gbifID");
+CREATE INDEX IF NOT EXISTS "sightings_state_date" ON "sightings" ("stateProvince", "date_only");
+CREATE INDEX IF NOT EXISTS "sightings_gbifid" ON "sightings" ("gbifID");
+CREATE INDEX IF NOT EXISTS "sightings_source_table" ON "sightings" ("source_table");
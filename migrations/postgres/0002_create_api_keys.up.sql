@@ -0,0 +1,12 @@
+-- API keys. Only the SHA-256 hash of a key is stored; scopes and roles are
+-- comma-separated.
+CREATE TABLE IF NOT EXISTS "api_keys" (
+	"id" TEXT PRIMARY KEY,
+	"name" TEXT NOT NULL,
+	"key_hash" TEXT NOT NULL UNIQUE,
+	"scopes" TEXT NOT NULL,
+	"roles" TEXT NOT NULL DEFAULT '',
+	"created_at" TIMESTAMPTZ NOT NULL,
+	"last_used_at" TIMESTAMPTZ,
+	"revoked_at" TIMESTAMPTZ
+);
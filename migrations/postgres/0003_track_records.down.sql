@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS "sightings_record_key";
+
+ALTER TABLE "sightings"
+	DROP COLUMN IF EXISTS "record_key",
+	DROP COLUMN IF EXISTS "first_seen_at",
+	DROP COLUMN IF EXISTS "last_seen_at",
+	DROP COLUMN IF EXISTS "missing_since";
@@ -0,0 +1,16 @@
+-- Record tracking for repeated imports. record_key identifies an occurrence
+-- across downloads: its gbifID, or else its datasetKey and occurrenceID.
+-- last_seen_at is the last import containing the record; missing_since is
+-- set by the first import covering its day that no longer contains it.
+ALTER TABLE "sightings"
+	ADD COLUMN IF NOT EXISTS "record_key" TEXT,
+	ADD COLUMN IF NOT EXISTS "first_seen_at" TIMESTAMPTZ,
+	ADD COLUMN IF NOT EXISTS "last_seen_at" TIMESTAMPTZ,
+	ADD COLUMN IF NOT EXISTS "missing_since" TIMESTAMPTZ;
+
+UPDATE "sightings" SET "record_key" = COALESCE(
+	'gbif:' || NULLIF("gbifID", ''),
+	'occ:' || NULLIF("datasetKey", '') || ':' || NULLIF("occurrenceID", '')
+) WHERE "record_key" IS NULL;
+
+CREATE INDEX IF NOT EXISTS "sightings_record_key" ON "sightings" ("record_key");
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS "sightings_record_key";
+CREATE INDEX IF NOT EXISTS "sightings_record_key" ON "sightings" ("record_key");
@@ -0,0 +1,9 @@
+-- Imports merge by record_key, so a record may appear once per day. Copies
+-- left behind by overlapping imports or repeated consolidations are dropped
+-- first, keeping the last row written. The partition key has to be part of
+-- a unique index on the partitioned table.
+DELETE FROM "sightings" a USING "sightings" b
+WHERE a."record_key" = b."record_key" AND a."date_only" = b."date_only" AND a.ctid < b.ctid;
+
+DROP INDEX IF EXISTS "sightings_record_key";
+CREATE UNIQUE INDEX IF NOT EXISTS "sightings_record_key" ON "sightings" ("record_key", "date_only");
@@ -0,0 +1,41 @@
+-- SQLite keeps every day in one "sightings" table with the 35 columns of
+-- MyMonarchRecord.
+CREATE TABLE IF NOT EXISTS "sightings" (
+	"gbifID" TEXT,
+	"datasetKey" TEXT,
+	"publishingOrgKey" TEXT,
+	"eventDate" TEXT,
+	"eventDateParsed" TIMESTAMP,
+	"year" INTEGER,
+	"month" INTEGER,
+	"day" INTEGER,
+	"day_of_week" INTEGER,
+	"week_of_year" INTEGER,
+	"date_only" TEXT,
+	"scientificName" TEXT,
+	"vernacularName" TEXT,
+	"taxonKey" INTEGER,
+	"kingdom" TEXT,
+	"phylum" TEXT,
+	"class" TEXT,
+	"order" TEXT,
+	"family" TEXT,
+	"genus" TEXT,
+	"species" TEXT,
+	"decimalLatitude" REAL,
+	"decimalLongitude" REAL,
+	"coordinateUncertaintyInMeters" REAL,
+	"countryCode" TEXT,
+	"stateProvince" TEXT,
+	"individualCount" INTEGER,
+	"basisOfRecord" TEXT,
+	"recordedBy" TEXT,
+	"occurrenceID" TEXT,
+	"collectionCode" TEXT,
+	"catalogNumber" TEXT,
+	"county" TEXT,
+	"cityOrTown" TEXT,
+	"time_only" TEXT
+);
+
+CREATE INDEX IF NOT EXISTS "sightings_date_only" ON "sightings" ("date_only", "time_only", "gbifID");
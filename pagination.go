@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// defaultPageSize applies when a cursor is given without a limit.
+	defaultPageSize = 1000
+	// maxPageSize caps the limit parameter.
+	maxPageSize = 10000
+)
+
+// pageParams are the pagination and streaming options of a sightings request.
+type pageParams struct {
+	// Limit is the page size; zero means the whole result in one response.
+	Limit  int
+	After  *SightingCursor
+	Stream bool
+}
+
+// parsePageParams reads limit, cursor and stream from the query string.
+func parsePageParams(r *http.Request) (pageParams, error) {
+	query := r.URL.Query()
+	var p pageParams
+
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return pageParams{}, fmt.Errorf("limit must be between 1 and %d, got %q", maxPageSize, v)
+		}
+		p.Limit = n
+	}
+	if v := query.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			return pageParams{}, err
+		}
+		p.After = &c
+		if p.Limit == 0 {
+			p.Limit = defaultPageSize
+		}
+	}
+	if v := query.Get("stream"); v != "" {
+		stream, err := strconv.ParseBool(v)
+		if err != nil {
+			return pageParams{}, fmt.Errorf("stream must be true or false, got %q", v)
+		}
+		p.Stream = stream
+	}
+	return p, nil
+}
+
+// apply sets the cursor and limit on f. One row beyond the page is requested
+// so the handler can tell whether another page follows.
+func (p pageParams) apply(f *SightingFilter) {
+	f.After = p.After
+	if p.Limit > 0 {
+		f.Limit = p.Limit + 1
+	}
+}
+
+// trimPage cuts records down to the page size and returns the cursor of the
+// next page, or "" when this is the last one.
+func (p pageParams) trimPage(records []MyMonarchRecord) ([]MyMonarchRecord, string) {
+	if p.Limit == 0 || len(records) <= p.Limit {
+		return records, ""
+	}
+	records = records[:p.Limit]
+	return records, cursorFor(records[len(records)-1]).Encode()
+}
+
+// nextPageURL returns the request URL with its cursor replaced by next.
+func nextPageURL(r *http.Request, next string) string {
+	u := url.URL{Path: r.URL.Path}
+	query := r.URL.Query()
+	query.Set("cursor", next)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// setNextLink advertises the next page in a Link header.
+func setNextLink(h http.Header, r *http.Request, next string) {
+	if next == "" {
+		return
+	}
+	h.Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextPageURL(r, next)))
+	h.Set("X-Next-Cursor", next)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// accessPolicy decides how much of a sighting a caller may see. The zero
+// value grants full access.
+type accessPolicy struct {
+	Name string
+	// CoordinateGrid, when positive, snaps coordinates to the center of a
+	// grid cell this many degrees wide and raises coordinateUncertaintyInMeters
+	// to cover the cell.
+	CoordinateGrid float64
+	// RedactRecordedBy hides the observer's name.
+	RedactRecordedBy bool
+}
+
+// adminRole is the role granted full access and the API key admin routes.
+const adminRole = "Game Admin"
+
+// publicPolicy applies to anonymous callers and to sessions without any of
+// the roles in rolePolicies.
+var publicPolicy = accessPolicy{Name: "public", CoordinateGrid: 0.1, RedactRecordedBy: true}
+
+// rolePolicies declares the policy granted by each role.
+var rolePolicies = map[string]accessPolicy{
+	adminRole: {Name: "admin"},
+}
+
+// policyFor returns the policy of the caller in ctx. A caller holding
+// several roles gets the most permissive combination of their policies.
+func policyFor(ctx context.Context) accessPolicy {
+	session, ok := sessionFrom(ctx)
+	if !ok {
+		return publicPolicy
+	}
+
+	policy, found := accessPolicy{}, false
+	for _, role := range session.Roles {
+		p, ok := rolePolicies[role]
+		if !ok {
+			continue
+		}
+		if !found {
+			policy, found = p, true
+			continue
+		}
+		policy = policy.widen(p)
+	}
+	if !found {
+		return publicPolicy
+	}
+	return policy
+}
+
+// widen combines two policies, keeping whatever either one allows.
+func (p accessPolicy) widen(o accessPolicy) accessPolicy {
+	if o.CoordinateGrid == 0 || (p.CoordinateGrid > 0 && o.CoordinateGrid < p.CoordinateGrid) {
+		p.CoordinateGrid = o.CoordinateGrid
+	}
+	p.RedactRecordedBy = p.RedactRecordedBy && o.RedactRecordedBy
+	if o.Name != p.Name {
+		p.Name = p.Name + "+" + o.Name
+	}
+	return p
+}
+
+// apply returns record as the policy allows it to be seen. Pointer fields
+// are replaced rather than written through, since stores may share them.
+func (p accessPolicy) apply(record MyMonarchRecord) MyMonarchRecord {
+	if p.RedactRecordedBy {
+		record.RecordedBy = nil
+	}
+	if p.CoordinateGrid > 0 && record.DecimalLatitude != nil && record.DecimalLongitude != nil {
+		lat := snapToGrid(*record.DecimalLatitude, p.CoordinateGrid)
+		lon := snapToGrid(*record.DecimalLongitude, p.CoordinateGrid)
+		record.DecimalLatitude, record.DecimalLongitude = &lat, &lon
+
+		cell := gridCellMeters(p.CoordinateGrid)
+		if record.CoordinateUncertaintyInMeters == nil || *record.CoordinateUncertaintyInMeters < cell {
+			cell = math.Round(cell)
+			record.CoordinateUncertaintyInMeters = &cell
+		}
+	}
+	return record
+}
+
+// gridCellMeters returns how far the center of a grid cell can be from a
+// point in it: half the cell's diagonal.
+func gridCellMeters(grid float64) float64 {
+	return grid * metersPerDegreeLat * math.Sqrt2 / 2
+}
+
+// snapToGrid returns the center of the grid cell holding v, rounded to drop
+// floating point noise.
+func snapToGrid(v, grid float64) float64 {
+	center := math.Floor(v/grid)*grid + grid/2
+	return math.Round(center*1e6) / 1e6
+}
+
+// checkFilter rejects filters that would reveal what the policy hides: a
+// spatial filter finer than the coordinate grid narrows a sighting down
+// past its published cell, and a recordedBy filter confirms a redacted name.
+// Filters that pass must still be matched against published positions; see
+// SightingFilter.CoordinateGrid.
+func (p accessPolicy) checkFilter(f SightingFilter) error {
+	if p.RedactRecordedBy && f.RecordedBy != "" {
+		return fmt.Errorf("filtering by recordedBy is not available to %s callers", p.Name)
+	}
+	if p.CoordinateGrid == 0 {
+		return nil
+	}
+	if b := f.BBox; b != nil {
+		lonSpan := b.MaxLon - b.MinLon
+		if lonSpan < 0 {
+			lonSpan += 360
+		}
+		if b.MaxLat-b.MinLat < p.CoordinateGrid || lonSpan < p.CoordinateGrid {
+			return fmt.Errorf("bbox must span at least %g degrees for %s callers", p.CoordinateGrid, p.Name)
+		}
+	}
+	if n := f.Near; n != nil {
+		minKm := p.CoordinateGrid * metersPerDegreeLat / 1000
+		if n.RadiusKm < minKm {
+			return fmt.Errorf("radiusKm must be at least %.1f for %s callers", minKm, p.Name)
+		}
+	}
+	return nil
+}
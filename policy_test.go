@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGeneralizedFiltersCannotSplitACell(t *testing.T) {
+	// Both sightings lie in the 0.1° cell centered on 29.25, -95.15.
+	records := []MyMonarchRecord{sighting("1", 29.22893, -95.1234), sighting("2", 29.2011, -95.1987)}
+	day := time.Date(2025, 6, 21, 0, 0, 0, 0, time.UTC)
+	grid := publicPolicy.CoordinateGrid
+
+	for name, store := range testStores(t, records) {
+		t.Run(name, func(t *testing.T) {
+			matched := func(f SightingFilter) string {
+				f.Start, f.End, f.CoordinateGrid = day, day, grid
+				result, err := store.Filter(context.Background(), f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids := ""
+				for _, r := range result.Records {
+					ids += *r.GBIFID
+				}
+				return ids
+			}
+
+			// A box one cell tall slid across the cell in small steps
+			// either holds both sightings or neither.
+			for minLat := 29.10; minLat < 29.30; minLat += 0.0007 {
+				bbox := &BoundingBox{MinLon: -95.3, MinLat: minLat, MaxLon: -95.0, MaxLat: minLat + 0.1}
+				if got := matched(SightingFilter{BBox: bbox}); got != "" && got != "12" && got != "21" {
+					t.Fatalf("bbox from minLat %.4f matched only %q", minLat, got)
+				}
+			}
+			for lat := 29.05; lat < 29.45; lat += 0.0013 {
+				near := &RadiusFilter{Lat: lat, Lon: -95.15, RadiusKm: 11.2}
+				if got := matched(SightingFilter{Near: near}); got != "" && got != "12" && got != "21" {
+					t.Fatalf("near %.4f matched only %q", lat, got)
+				}
+			}
+
+			// The box from the report, moved by 0.0001° past the true
+			// latitude, still matches: only the cell center counts.
+			for _, minLat := range []float64{29.2289, 29.2290} {
+				bbox := &BoundingBox{MinLon: -95.3, MinLat: minLat, MaxLon: -95.0, MaxLat: minLat + 0.1}
+				if got := matched(SightingFilter{BBox: bbox}); got != "12" && got != "21" {
+					t.Errorf("bbox from minLat %g matched %q, want both", minLat, got)
+				}
+			}
+		})
+	}
+}
+
+func TestPublicBBoxRequestUsesPublishedPositions(t *testing.T) {
+	srv := &server{store: newMemoryStore([]MyMonarchRecord{sighting("1", 29.22893, -95.1234)})}
+	for _, minLat := range []string{"29.2289", "29.2290"} {
+		url := fmt.Sprintf("/monarchbutterlies/range?start=06212025&end=06212025&bbox=-95.3,%s,-95.0,%s", minLat, "29.35")
+		rec := httptest.NewRecorder()
+		srv.getRangeScan(rec, httptest.NewRequest("GET", url, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		var body struct {
+			Records []MyMonarchRecord `json:"records"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Records) != 1 {
+			t.Fatalf("minLat %s: %d records, want 1", minLat, len(body.Records))
+		}
+		if lat := *body.Records[0].DecimalLatitude; lat != 29.25 {
+			t.Errorf("published latitude = %v, want 29.25", lat)
+		}
+	}
+}
+
+func TestAccessPolicyApply(t *testing.T) {
+	record := sighting("1", 35.48195, -97.57012)
+	record.CoordinateUncertaintyInMeters = ptr(4.0)
+
+	public := publicPolicy.apply(record)
+	if public.RecordedBy != nil {
+		t.Errorf("public recordedBy = %q, want it redacted", *public.RecordedBy)
+	}
+	if *public.DecimalLatitude != 35.45 || *public.DecimalLongitude != -97.55 {
+		t.Errorf("public position = %v, %v, want the cell center 35.45, -97.55", *public.DecimalLatitude, *public.DecimalLongitude)
+	}
+	if got, want := *public.CoordinateUncertaintyInMeters, math.Round(gridCellMeters(0.1)); got != want {
+		t.Errorf("public uncertainty = %v, want %v", got, want)
+	}
+	// The stored record is left as it was.
+	if *record.DecimalLatitude != 35.48195 || *record.RecordedBy != "Maria Lopez" || *record.CoordinateUncertaintyInMeters != 4 {
+		t.Errorf("apply changed the stored record: %+v", record)
+	}
+
+	// An uncertainty larger than the cell is kept.
+	record.CoordinateUncertaintyInMeters = ptr(25000.0)
+	if got := *publicPolicy.apply(record).CoordinateUncertaintyInMeters; got != 25000 {
+		t.Errorf("public uncertainty = %v, want 25000 kept", got)
+	}
+	// Records without coordinates keep their uncertainty as is.
+	if got := publicPolicy.apply(MyMonarchRecord{}); got.DecimalLatitude != nil || got.CoordinateUncertaintyInMeters != nil {
+		t.Errorf("public record without coordinates = %+v", got)
+	}
+
+	admin := rolePolicies[adminRole].apply(record)
+	if *admin.DecimalLatitude != 35.48195 || *admin.DecimalLongitude != -97.57012 || *admin.RecordedBy != "Maria Lopez" {
+		t.Errorf("admin record = %+v, want it unchanged", admin)
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		session *Session
+		want    string
+	}{
+		{"anonymous", nil, "public"},
+		{"no known role", &Session{UserID: "u", Roles: []string{"Viewer"}}, "public"},
+		{"admin", &Session{UserID: "u", Roles: []string{"Viewer", adminRole}}, "admin"},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.session != nil {
+			ctx = withSession(ctx, *tt.session)
+		}
+		if got := policyFor(ctx).Name; got != tt.want {
+			t.Errorf("%s: policy = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
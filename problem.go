@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Problem codes. They are part of the API: clients branch on them, so
+// existing codes keep their meaning.
+const (
+	codeInvalidDate        = "invalid_date"
+	codeInvalidParameter   = "invalid_parameter"
+	codeInvalidBody        = "invalid_body"
+	codeStreamDisabled     = "stream_disabled"
+	codeTableNotFound      = "table_not_found"
+	codeNotFound           = "not_found"
+	codeMethodNotAllowed   = "method_not_allowed"
+	codeUnauthorized       = "unauthorized"
+	codeInvalidCredentials = "invalid_credentials"
+	codeForbidden          = "forbidden"
+	codeRateLimited        = "rate_limited"
+	codeQuotaExhausted     = "quota_exhausted"
+	codeDBUnavailable      = "db_unavailable"
+	codeDBError            = "db_error"
+	codeInternal           = "internal_error"
+)
+
+// problem is an RFC 7807 problem details body. Detail is shown to clients,
+// so it never carries database or driver messages; those are only logged.
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// writeProblem writes an application/problem+json error response.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	}
+	if id, ok := r.Context().Value(contextKeyRequestID).(string); ok {
+		p.RequestID = id
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// notFoundHandler and methodNotAllowedHandler answer requests the router
+// has no route for.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusNotFound, codeNotFound, "No route for "+r.URL.Path)
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// failingStore is a memoryStore whose queries fail with err.
+type failingStore struct {
+	*memoryStore
+	err error
+}
+
+func (s *failingStore) Filter(context.Context, SightingFilter) (SightingResult, error) {
+	return SightingResult{}, s.err
+}
+
+func TestStoreErrorProblems(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"dial refused", newStoreError(stageQuery, "june212025", refused), http.StatusServiceUnavailable, codeDBUnavailable},
+		{"bad connection", newStoreError(stageIterate, "june212025", driver.ErrBadConn), http.StatusServiceUnavailable, codeDBUnavailable},
+		{"server shutting down", newStoreError(stageQuery, "june212025", &pq.Error{Code: "57P01"}), http.StatusServiceUnavailable, codeDBUnavailable},
+		{"refused message", newStoreError(stageQuery, "june212025", errors.New("dial tcp 10.0.0.5:5432: connect: connection refused")), http.StatusServiceUnavailable, codeDBUnavailable},
+		{"syntax error", newStoreError(stageQuery, "june212025", &pq.Error{Code: "42601", Message: "syntax error"}), http.StatusInternalServerError, codeDBError},
+		{"scan error", newStoreError(stageScan, "june212025", errors.New("converting NULL to int")), http.StatusInternalServerError, codeDBError},
+		{"undefined table", newStoreError(stageQuery, "june222025", &pq.Error{Code: "42P01"}), http.StatusNotFound, codeTableNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &server{store: &failingStore{memoryStore: newMemoryStore(nil), err: tt.err}}
+			rec := httptest.NewRecorder()
+			srv.getRangeScan(rec, httptest.NewRequest("GET", "/monarchbutterlies/range?start=2025-06-21&end=2025-06-21", nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			var p problem
+			if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Code != tt.code {
+				t.Errorf("code = %q, want %q", p.Code, tt.code)
+			}
+			if p.Detail == "" || p.Detail == tt.err.Error() {
+				t.Errorf("detail %q should be a sanitized message", p.Detail)
+			}
+		})
+	}
+}
+
+func TestNewStoreErrorStage(t *testing.T) {
+	if se := newStoreError(stageScan, "t", errors.New("bad value")); se.Stage != stageScan {
+		t.Errorf("stage = %q, want %q", se.Stage, stageScan)
+	}
+	if se := newStoreError(stageScan, "t", driver.ErrBadConn); se.Stage != stageConnect {
+		t.Errorf("stage = %q, want %q", se.Stage, stageConnect)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// getRangeScan serves the sightings between the start and end query
+// parameters (inclusive, in any form parseDate accepts) that match the
+// field filters, merged across the daily tables and ordered by date_only,
+// time_only and gbifID. Days without data are listed in missingDays rather
+// than failing the request. Results can be paged with limit/cursor or
+// streamed with stream=true.
+func (s *server) getRangeScan(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	now := time.Now()
+	startDate, err := parseDate(query.Get("start"), now)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidDate, fmt.Sprintf("Invalid start date: %v", err))
+		return
+	}
+	endDate, err := parseDate(query.Get("end"), now)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidDate, fmt.Sprintf("Invalid end date: %v", err))
+		return
+	}
+
+	filter, err := parseSightingFilter(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	start, end := startDate.Time(), endDate.Time()
+	filter.Start, filter.End = start, end
+
+	page, err := parsePageParams(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+
+	slog.DebugContext(r.Context(), "range requested", "start", start.Format("2006-01-02"), "end", end.Format("2006-01-02"))
+	s.serveSightings(w, r, filter, page, responseMeta{
+		Start: start.Format("2006-01-02"),
+		End:   end.Format("2006-01-02"),
+	})
+}
+
+// formatDays renders days as YYYY-MM-DD strings, never returning nil.
+func formatDays(days []time.Time) []string {
+	out := make([]string, 0, len(days))
+	for _, day := range days {
+		out = append(out, day.Format("2006-01-02"))
+	}
+	return out
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route classes with separate rate limits. Heavy routes read whole days or
+// ranges of sightings; everything else is light. Probe routes are not
+// limited, so orchestrator health checks never see a 429.
+const (
+	routeLight = "light"
+	routeHeavy = "heavy"
+	routeProbe = "probe"
+)
+
+// heavyRoutes are the path templates of the heavy routes, without the /api
+// prefix. /stats/counts returns few rows but reads as many daily tables as a
+// range scan.
+var heavyRoutes = map[string]bool{
+	"/monarchbutterlies/dayscan/{calendarDate}": true,
+	"/monarchbutterlies/range":                  true,
+	"/monarchsjune2025":                         true,
+	"/stats/counts":                             true,
+}
+
+// probeRoutes are the path templates of the health, build info and metrics
+// routes.
+var probeRoutes = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/version": true,
+	"/metrics": true,
+}
+
+// routeClass returns the class of the route mux matched for r.
+func routeClass(r *http.Request) string {
+	template := routeTemplate(r)
+	if probeRoutes[template] {
+		return routeProbe
+	}
+	if heavyRoutes[strings.TrimPrefix(template, "/api")] {
+		return routeHeavy
+	}
+	return routeLight
+}
+
+// rateLimit is a token bucket: Burst requests at once, refilled at PerMinute.
+type rateLimit struct {
+	PerMinute float64 `yaml:"perMinute"`
+	Burst     float64 `yaml:"burst"`
+}
+
+// tokenBucket is one client's bucket for one route class.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rowQuota is one API key's row count for the current UTC day.
+type rowQuota struct {
+	day  string
+	rows int64
+}
+
+// rateLimiter enforces per-client token buckets and per-key daily row
+// quotas. State is kept in memory, so limits are per process.
+type rateLimiter struct {
+	cfg     rateLimitConfig
+	proxies []netip.Prefix
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	quotas    map[string]*rowQuota
+	lastSweep time.Time
+}
+
+func newRateLimiter(cfg rateLimitConfig) *rateLimiter {
+	// loadConfig has already validated the proxies.
+	proxies, _ := parseTrustedProxies(cfg.TrustedProxies)
+	return &rateLimiter{
+		cfg:     cfg,
+		proxies: proxies,
+		buckets: make(map[string]*tokenBucket),
+		quotas:  make(map[string]*rowQuota),
+	}
+}
+
+// parseTrustedProxies reads proxy addresses given as IPs or CIDR prefixes.
+func parseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, p := range proxies {
+		if strings.Contains(p, "/") {
+			prefix, err := netip.ParsePrefix(p)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(p)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// trusted reports whether ip is one of the trusted proxies.
+func (l *rateLimiter) trusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range l.proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientKey identifies the caller: the session user (API keys are
+// "apikey:<id>") or else the client IP.
+func (l *rateLimiter) clientKey(r *http.Request) string {
+	if session, ok := sessionFrom(r.Context()); ok {
+		return session.UserID
+	}
+	return "ip:" + l.clientIP(r)
+}
+
+// clientIP returns the address of the caller. Behind trusted proxies it is
+// the rightmost X-Forwarded-For hop that is not itself a trusted proxy: the
+// address the outermost trusted proxy saw. Hops to its left were written by
+// the client and are ignored, so a forged header cannot change the key.
+func (l *rateLimiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !l.trusted(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !l.trusted(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+// take removes a token from the client's bucket for class. It returns the
+// tokens left and, when the bucket is empty, how long until one refills.
+func (l *rateLimiter) take(client, class string, now time.Time) (remaining float64, wait time.Duration) {
+	limit := l.cfg.limit(class)
+	rate := limit.PerMinute / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	key := class + "|" + client
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: limit.Burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(limit.Burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		if rate <= 0 {
+			return 0, time.Hour
+		}
+		return b.tokens, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return b.tokens, 0
+}
+
+// sweep drops buckets idle long enough to have refilled, so one-off clients
+// do not accumulate. l.mu must be held.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > 10*time.Minute {
+			delete(l.buckets, key)
+		}
+	}
+	today := now.UTC().Format("2006-01-02")
+	for key, q := range l.quotas {
+		if q.day != today {
+			delete(l.quotas, key)
+		}
+	}
+}
+
+// reserveRows charges everything left of the client's quota for today
+// and returns it, so that concurrent requests cannot spend the same rows.
+// The request gives back what it did not use with refundRows.
+func (l *rateLimiter) reserveRows(client string, now time.Time) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	today := now.UTC().Format("2006-01-02")
+	q, ok := l.quotas[client]
+	if !ok || q.day != today {
+		q = &rowQuota{day: today}
+		l.quotas[client] = q
+	}
+	left := max(l.cfg.APIKeyDailyRows-q.rows, 0)
+	q.rows += left
+	return left
+}
+
+// refundRows returns rows reserved at now to the client's quota, unless
+// the day has turned since.
+func (l *rateLimiter) refundRows(client string, rows int64, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if q, ok := l.quotas[client]; ok && q.day == now.UTC().Format("2006-01-02") {
+		q.rows -= rows
+	}
+}
+
+// quotaApplies reports whether client is subject to the daily row quota.
+func (l *rateLimiter) quotaApplies(client string) bool {
+	return l.cfg.APIKeyDailyRows > 0 && strings.HasPrefix(client, "apikey:")
+}
+
+// middleware applies the bucket of the matched route's class, setting the
+// RateLimit-Limit/-Remaining/-Reset headers on every response and
+// Retry-After on 429s. Heavy requests made with an API key are also checked
+// against the key's daily row quota, and may return no more rows than the
+// quota has left. It runs after sessionValidationMiddleware so sessions are
+// limited by user.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		class := routeClass(r)
+		if class == routeProbe {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client := l.clientKey(r)
+		limit := l.cfg.limit(class)
+
+		remaining, wait := l.take(client, class, now)
+		h := w.Header()
+		h.Set("RateLimit-Policy", fmt.Sprintf("%g;w=60;burst=%g", limit.PerMinute, limit.Burst))
+		h.Set("RateLimit-Limit", strconv.FormatFloat(limit.Burst, 'f', 0, 64))
+		h.Set("RateLimit-Remaining", strconv.FormatFloat(math.Floor(remaining), 'f', 0, 64))
+		if wait > 0 {
+			retry := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+			h.Set("RateLimit-Reset", retry)
+			h.Set("Retry-After", retry)
+			writeProblem(w, r, http.StatusTooManyRequests, codeRateLimited, "Too many requests, retry later")
+			return
+		}
+		h.Set("RateLimit-Reset", "0")
+
+		if class != routeHeavy || !l.quotaApplies(client) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		left := l.reserveRows(client, now)
+		h.Set("X-Row-Quota-Limit", strconv.FormatInt(l.cfg.APIKeyDailyRows, 10))
+		h.Set("X-Row-Quota-Remaining", strconv.FormatInt(left, 10))
+		if left == 0 {
+			midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+			h.Set("Retry-After", strconv.Itoa(int(math.Ceil(midnight.Sub(now).Seconds()))))
+			writeProblem(w, r, http.StatusTooManyRequests, codeQuotaExhausted, "Daily row quota exhausted")
+			return
+		}
+
+		usage, ok := r.Context().Value(contextKeyRowUsage).(*rowUsage)
+		if !ok {
+			usage = &rowUsage{}
+			r = r.WithContext(context.WithValue(r.Context(), contextKeyRowUsage, usage))
+		}
+		before := usage.rows
+		usage.allowance = before + left
+		defer func() { l.refundRows(client, left-(usage.rows-before), now) }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// contextKeyRowUsage carries the *rowUsage of a request, set by requestLogger
+// for the access log and read by the row quota.
+const contextKeyRowUsage contextKey = "rowUsage"
+
+// rowUsage collects the rows a request returned.
+type rowUsage struct {
+	rows int64
+	// allowance, when positive, is the most rows the request may return:
+	// what is left of the caller's daily quota.
+	allowance int64
+}
+
+// rowAllowance returns how many rows the request may still return, or false
+// when it is not subject to a quota.
+func rowAllowance(ctx context.Context) (int64, bool) {
+	usage, ok := ctx.Value(contextKeyRowUsage).(*rowUsage)
+	if !ok || usage.allowance <= 0 {
+		return 0, false
+	}
+	return usage.allowance - usage.rows, true
+}
+
+// countRows records n returned rows against the request.
+func countRows(ctx context.Context, n int) {
+	usage, ok := ctx.Value(contextKeyRowUsage).(*rowUsage)
+	if !ok {
+		return
+	}
+	usage.rows += int64(n)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxies   []string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"no proxy ignores the header", nil, "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"untrusted peer ignores the header", []string{"10.0.0.1"}, "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", []string{"10.0.0.1"}, "10.0.0.1:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"forged hops are skipped", []string{"10.0.0.1"}, "10.0.0.1:5000", []string{"1.2.3.4, 5.6.7.8, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", []string{"10.0.0.0/8"}, "10.0.0.1:5000", []string{"1.2.3.4, 198.51.100.1, 10.1.2.3"}, "198.51.100.1"},
+		{"repeated headers", []string{"10.0.0.1"}, "10.0.0.1:5000", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"trusted proxy without header", []string{"10.0.0.1"}, "10.0.0.1:5000", nil, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(rateLimitConfig{TrustedProxies: tt.proxies})
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := l.clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForgedForwardedForSharesBucket(t *testing.T) {
+	l := newRateLimiter(rateLimitConfig{
+		Light:          rateLimit{PerMinute: 1, Burst: 1},
+		Heavy:          rateLimit{PerMinute: 1, Burst: 1},
+		TrustedProxies: []string{"10.0.0.1"},
+	})
+	router := mux.NewRouter()
+	router.Use(l.middleware)
+	router.HandleFunc("/catalog", func(w http.ResponseWriter, r *http.Request) {})
+
+	for i, forged := range []string{"1.1.1.1", "2.2.2.2"} {
+		r := httptest.NewRequest("GET", "/catalog", nil)
+		r.RemoteAddr = "10.0.0.1:5000"
+		r.Header.Set("X-Forwarded-For", forged+", 198.51.100.1")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		if want := []int{http.StatusOK, http.StatusTooManyRequests}[i]; rec.Code != want {
+			t.Fatalf("request %d: status = %d, want %d", i, rec.Code, want)
+		}
+	}
+}
+
+func TestStatsCountsIsHeavy(t *testing.T) {
+	router := mux.NewRouter()
+	var class string
+	record := func(w http.ResponseWriter, r *http.Request) { class = routeClass(r) }
+	router.HandleFunc("/stats/counts", record)
+	router.HandleFunc("/api/stats/counts", record)
+	router.HandleFunc("/catalog", record)
+
+	for path, want := range map[string]string{"/stats/counts": routeHeavy, "/api/stats/counts": routeHeavy, "/catalog": routeLight} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		if class != want {
+			t.Errorf("%s: class = %q, want %q", path, class, want)
+		}
+	}
+}
+
+func TestRowQuotaCapsResults(t *testing.T) {
+	records, err := loadFixtures("fixtures/sightings.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &server{store: newMemoryStore(records), streaming: true}
+	l := newRateLimiter(rateLimitConfig{
+		Light:           rateLimit{PerMinute: 600, Burst: 100},
+		Heavy:           rateLimit{PerMinute: 600, Burst: 100},
+		APIKeyDailyRows: 3,
+	})
+	router := mux.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session := Session{UserID: "apikey:" + r.Header.Get("X-Test-Key")}
+			next.ServeHTTP(w, r.WithContext(withSession(r.Context(), session)))
+		})
+	})
+	router.Use(l.middleware)
+	router.HandleFunc("/monarchbutterlies/range", srv.getRangeScan)
+
+	get := func(key, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/monarchbutterlies/range?start=2025-06-19&end=2025-06-23"+query, nil)
+		r.Header.Set("X-Test-Key", key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		return rec
+	}
+	page := func(rec *httptest.ResponseRecorder) (n int, next string) {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		var body struct {
+			Records    []MyMonarchRecord `json:"records"`
+			NextCursor string            `json:"nextCursor"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return len(body.Records), body.NextCursor
+	}
+
+	// A page within the quota is served whole; the next one is cut to what
+	// is left, with a cursor to resume from.
+	if n, _ := page(get("k1", "&limit=2")); n != 2 {
+		t.Fatalf("first page has %d records, want 2", n)
+	}
+	if n, next := page(get("k1", "&limit=1000")); n != 1 || next == "" {
+		t.Fatalf("capped page has %d records and cursor %q, want 1 and a cursor", n, next)
+	}
+	if rec := get("k1", "&limit=1"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("after the quota: status = %d, want 429", rec.Code)
+	}
+
+	// An unpaged export stops at the quota too.
+	rec := get("k2", "&stream=true&format=csv")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export status = %d: %s", rec.Code, rec.Body)
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("export has %d lines, want a header and 3 rows", len(rows))
+	}
+}
+
+func TestRowQuotaReservation(t *testing.T) {
+	l := newRateLimiter(rateLimitConfig{APIKeyDailyRows: 10})
+	now := time.Date(2025, 6, 21, 12, 0, 0, 0, time.UTC)
+
+	// A request in flight holds the whole quota; what it does not use is
+	// given back when it finishes.
+	if left := l.reserveRows("apikey:k1", now); left != 10 {
+		t.Fatalf("first reservation = %d, want 10", left)
+	}
+	if left := l.reserveRows("apikey:k1", now); left != 0 {
+		t.Fatalf("concurrent reservation = %d, want 0", left)
+	}
+	l.refundRows("apikey:k1", 7, now)
+	if left := l.reserveRows("apikey:k1", now); left != 7 {
+		t.Fatalf("reservation after the refund = %d, want 7", left)
+	}
+
+	// A refund from yesterday does not touch today's quota.
+	tomorrow := now.Add(24 * time.Hour)
+	if left := l.reserveRows("apikey:k1", tomorrow); left != 10 {
+		t.Fatalf("next day's reservation = %d, want 10", left)
+	}
+	l.refundRows("apikey:k1", 7, now)
+	if left := l.reserveRows("apikey:k1", tomorrow); left != 0 {
+		t.Fatalf("reservation after a stale refund = %d, want 0", left)
+	}
+}
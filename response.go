@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// responseMeta describes a sightings response beyond its records. Start and
+// End are the requested dates as YYYY-MM-DD; SingleDay marks the dayscan
+// endpoint, whose JSON body is a bare array.
+type responseMeta struct {
+	SingleDay bool
+	Start     string
+	End       string
+
+	// Filled in once the records have been read.
+	Count       int
+	Skipped     int
+	MissingDays []string
+	NextCursor  string
+	Next        string
+}
+
+// sightingFormat renders a sightings response incrementally, so the same
+// code serves buffered and streamed responses.
+type sightingFormat interface {
+	contentType() string
+	// open writes everything before the first record.
+	open(w io.Writer, meta *responseMeta) error
+	// record writes one record; first is true for the first one written.
+	// It returns false when the record was left out of the output.
+	record(w io.Writer, record MyMonarchRecord, first bool) (bool, error)
+	// close writes everything after the last record.
+	close(w io.Writer, meta *responseMeta) error
+}
+
+// attachmentFormat is implemented by formats that are served as a download.
+type attachmentFormat interface {
+	filename(meta *responseMeta) string
+}
+
+// negotiateFormat picks the output format from the format query parameter,
+// falling back to the Accept header and then to plain JSON.
+func negotiateFormat(r *http.Request) (sightingFormat, error) {
+	query := r.URL.Query()
+
+	geo := geoJSONFormat{flagNull: query.Get("nullCoordinates") == "flag"}
+	if v := query.Get("nullCoordinates"); v != "" && v != "skip" && v != "flag" {
+		return nil, fmt.Errorf("nullCoordinates must be skip or flag, got %q", v)
+	}
+
+	switch strings.ToLower(query.Get("format")) {
+	case "json":
+		return jsonFormat{}, nil
+	case "geojson":
+		return geo, nil
+	case "csv":
+		return csvFormat, nil
+	case "tsv":
+		return tsvFormat, nil
+	case "":
+	default:
+		return nil, fmt.Errorf("unsupported format %q", query.Get("format"))
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/geo+json":
+			return geo, nil
+		case "text/csv":
+			return csvFormat, nil
+		case "text/tab-separated-values":
+			return tsvFormat, nil
+		case "application/json":
+			return jsonFormat{}, nil
+		}
+	}
+	return jsonFormat{}, nil
+}
+
+// jsonFormat is the original output: an array of records for a single day,
+// or an object with the records and range details for the range endpoint.
+type jsonFormat struct{}
+
+func (jsonFormat) contentType() string { return "application/json" }
+
+func (jsonFormat) open(w io.Writer, meta *responseMeta) error {
+	if meta.SingleDay {
+		_, err := io.WriteString(w, "[")
+		return err
+	}
+	_, err := fmt.Fprintf(w, `{"start":%q,"end":%q,"records":[`, meta.Start, meta.End)
+	return err
+}
+
+func (jsonFormat) record(w io.Writer, record MyMonarchRecord, first bool) (bool, error) {
+	return true, writeJSONElement(w, record, first)
+}
+
+func (jsonFormat) close(w io.Writer, meta *responseMeta) error {
+	if meta.SingleDay {
+		_, err := io.WriteString(w, "]\n")
+		return err
+	}
+	return writeJSONTail(w, rangeTail(meta))
+}
+
+// rangeTailFields are the range fields only known after the records are read.
+type rangeTailFields struct {
+	Count       int      `json:"count"`
+	MissingDays []string `json:"missingDays"`
+	NextCursor  string   `json:"nextCursor,omitempty"`
+	Next        string   `json:"next,omitempty"`
+}
+
+func rangeTail(meta *responseMeta) rangeTailFields {
+	return rangeTailFields{
+		Count:       meta.Count,
+		MissingDays: meta.MissingDays,
+		NextCursor:  meta.NextCursor,
+		Next:        meta.Next,
+	}
+}
+
+// geoJSONFormat writes a FeatureCollection of Point features. Rows without
+// coordinates are skipped, or with flagNull kept with a null geometry and a
+// coordinatesMissing property.
+type geoJSONFormat struct {
+	flagNull bool
+}
+
+func (geoJSONFormat) contentType() string { return "application/geo+json" }
+
+func (geoJSONFormat) open(w io.Writer, meta *responseMeta) error {
+	if meta.SingleDay {
+		_, err := io.WriteString(w, `{"type":"FeatureCollection","features":[`)
+		return err
+	}
+	_, err := fmt.Fprintf(w, `{"type":"FeatureCollection","start":%q,"end":%q,"features":[`, meta.Start, meta.End)
+	return err
+}
+
+// geoJSONFeature is one sighting as a GeoJSON Feature.
+type geoJSONFeature struct {
+	Type       string                     `json:"type"`
+	ID         *string                    `json:"id,omitempty"`
+	Geometry   *geoJSONPoint              `json:"geometry"`
+	Properties map[string]json.RawMessage `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+func (f geoJSONFormat) record(w io.Writer, record MyMonarchRecord, first bool) (bool, error) {
+	hasCoordinates := record.DecimalLatitude != nil && record.DecimalLongitude != nil
+	if !hasCoordinates && !f.flagNull {
+		return false, nil
+	}
+
+	// Every field except the coordinates becomes a property.
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	var properties map[string]json.RawMessage
+	if err := json.Unmarshal(data, &properties); err != nil {
+		return false, err
+	}
+	delete(properties, "decimalLatitude")
+	delete(properties, "decimalLongitude")
+
+	feature := geoJSONFeature{Type: "Feature", ID: record.GBIFID, Properties: properties}
+	if hasCoordinates {
+		// GeoJSON positions are longitude first.
+		feature.Geometry = &geoJSONPoint{Type: "Point", Coordinates: [2]float64{*record.DecimalLongitude, *record.DecimalLatitude}}
+	} else {
+		properties["coordinatesMissing"] = json.RawMessage("true")
+	}
+	return true, writeJSONElement(w, feature, first)
+}
+
+func (geoJSONFormat) close(w io.Writer, meta *responseMeta) error {
+	if meta.SingleDay {
+		return writeJSONTail(w, struct {
+			Count                  int    `json:"count"`
+			SkippedNullCoordinates int    `json:"skippedNullCoordinates"`
+			NextCursor             string `json:"nextCursor,omitempty"`
+			Next                   string `json:"next,omitempty"`
+		}{meta.Count, meta.Skipped, meta.NextCursor, meta.Next})
+	}
+	return writeJSONTail(w, struct {
+		rangeTailFields
+		SkippedNullCoordinates int `json:"skippedNullCoordinates"`
+	}{rangeTail(meta), meta.Skipped})
+}
+
+// writeJSONElement writes v as an element of an open JSON array.
+func writeJSONElement(w io.Writer, v any, first bool) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if !first {
+		if _, err := io.WriteString(w, ","); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// writeJSONTail closes an open array and splices the fields of v into the
+// enclosing object, which is then closed.
+func writeJSONTail(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(data) <= 2 {
+		_, err = io.WriteString(w, "]}\n")
+		return err
+	}
+	// Drop the tail's own "{" so its fields continue the open object.
+	_, err = fmt.Fprintf(w, "],%s\n", data[1:])
+	return err
+}
+
+// flushEvery is how many records are written between flushes when streaming.
+const flushEvery = 200
+
+// sightingWriter feeds records to a sightingFormat. The response is only
+// committed when the first byte is written, so a request that fails before
+// producing anything can still get a proper error status.
+type sightingWriter struct {
+	w      http.ResponseWriter
+	r      *http.Request
+	page   pageParams
+	format sightingFormat
+	policy accessPolicy
+	meta   responseMeta
+	// writeTimeout, when set, is renewed at every flush so a stream that
+	// keeps making progress outlives the server's write timeout.
+	writeTimeout time.Duration
+
+	started bool
+	written int
+	read    int
+	last    MyMonarchRecord
+	hasMore bool
+}
+
+func newSightingWriter(w http.ResponseWriter, r *http.Request, page pageParams, format sightingFormat, policy accessPolicy, meta responseMeta) *sightingWriter {
+	return &sightingWriter{w: w, r: r, page: page, format: format, policy: policy, meta: meta}
+}
+
+// start commits the response headers and the opening of the body.
+func (s *sightingWriter) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	s.w.Header().Set("Content-Type", s.format.contentType())
+	if a, ok := s.format.(attachmentFormat); ok {
+		s.w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.filename(&s.meta)}))
+	}
+	if s.page.Stream && s.page.Limit > 0 {
+		// The next cursor is only known once the page has been read.
+		s.w.Header().Set("Trailer", "Link, X-Next-Cursor")
+	}
+	s.w.WriteHeader(http.StatusOK)
+	return s.format.open(s.w, &s.meta)
+}
+
+// write is the Stream callback. It stops the stream one record past the
+// page, which only marks that another page exists. Records pass through the
+// caller's access policy before any format sees them.
+func (s *sightingWriter) write(record MyMonarchRecord) error {
+	if s.page.Limit > 0 && s.read == s.page.Limit {
+		s.hasMore = true
+		return errStopStream
+	}
+	s.read++
+	s.last = record
+
+	if err := s.start(); err != nil {
+		return err
+	}
+	ok, err := s.format.record(s.w, s.policy.apply(record), s.written == 0)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.meta.Skipped++
+		return nil
+	}
+	s.written++
+
+	if s.page.Stream && s.written%flushEvery == 0 {
+		if f, ok := s.w.(http.Flusher); ok {
+			f.Flush()
+		}
+		if s.writeTimeout > 0 {
+			http.NewResponseController(s.w).SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		}
+	}
+	return nil
+}
+
+// setNext records the cursor of the following page.
+func (s *sightingWriter) setNext(next string) {
+	if next == "" {
+		return
+	}
+	s.meta.NextCursor = next
+	s.meta.Next = nextPageURL(s.r, next)
+}
+
+// finish closes the body. A streamed page only learns its next cursor here,
+// so it is sent in the trailers declared by start.
+func (s *sightingWriter) finish(missing []time.Time) {
+	s.meta.Count = s.written
+	s.meta.MissingDays = formatDays(missing)
+	if s.hasMore {
+		s.setNext(cursorFor(s.last).Encode())
+	}
+
+	if err := s.start(); err != nil {
+		s.abort(err)
+		return
+	}
+	if err := s.format.close(s.w, &s.meta); err != nil {
+		s.abort(err)
+		return
+	}
+	if s.page.Stream {
+		setNextLink(s.w.Header(), s.r, s.meta.NextCursor)
+	}
+}
+
+// abort logs a failure after the response was committed. The body is left
+// truncated so clients see an invalid document rather than a partial success.
+func (s *sightingWriter) abort(err error) {
+	countStoreError(err)
+	slog.ErrorContext(s.r.Context(), "response aborted", "records", s.written, "error", err)
+}
+
+// serveSightings runs filter against the store and writes the result in the
+// negotiated format, buffered or streamed according to page, as the caller's
+// access policy allows. For a single day, a day without data is a 404.
+func (s *server) serveSightings(w http.ResponseWriter, r *http.Request, filter SightingFilter, page pageParams, meta responseMeta) {
+	if page.Stream && !s.streaming {
+		writeProblem(w, r, http.StatusBadRequest, codeStreamDisabled, "stream=true is disabled on this server")
+		return
+	}
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	policy := policyFor(r.Context())
+	if err := policy.checkFilter(filter); err != nil {
+		writeProblem(w, r, http.StatusForbidden, codeForbidden, err.Error())
+		return
+	}
+	filter.CoordinateGrid = policy.CoordinateGrid
+	if left, ok := rowAllowance(r.Context()); ok && (page.Limit == 0 || int64(page.Limit) > left) {
+		// A quota-bound caller gets what is left of the quota, with a
+		// cursor to continue from once it resets.
+		page.Limit = int(left)
+	}
+	page.apply(&filter)
+	out := newSightingWriter(w, r, page, format, policy, meta)
+	out.writeTimeout = s.writeTimeout
+	defer func() { countRows(r.Context(), out.read) }()
+
+	notFound := func() {
+		writeProblem(w, r, http.StatusNotFound, codeTableNotFound, fmt.Sprintf("No sightings recorded for %s", filter.Start.Format("2006-01-02")))
+	}
+
+	if page.Stream {
+		missing, err := s.store.Stream(r.Context(), filter, out.write)
+		if err != nil {
+			if out.started {
+				out.abort(err)
+				return
+			}
+			writeStoreError(w, r, err)
+			return
+		}
+		if meta.SingleDay && len(missing) > 0 && !out.started {
+			notFound()
+			return
+		}
+		out.finish(missing)
+		return
+	}
+
+	result, err := s.store.Filter(r.Context(), filter)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if meta.SingleDay && len(result.MissingDays) > 0 {
+		notFound()
+		return
+	}
+	records, next := page.trimPage(result.Records)
+	setNextLink(w.Header(), r, next)
+	out.setNext(next)
+
+	for _, record := range records {
+		if err := out.write(record); err != nil {
+			out.abort(err)
+			return
+		}
+	}
+	out.finish(result.MissingDays)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// newHTTPServer wraps handler in an http.Server with the configured
+// timeouts.
+func newHTTPServer(cfg serverConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+// serve runs srv until it fails or ctx is cancelled. On cancellation it
+// stops accepting connections and lets in-flight requests finish for up to
+// shutdownTimeout; requests still running after that have their contexts
+// cancelled, which aborts their database queries, and are closed.
+func serve(ctx context.Context, srv *http.Server, tls tlsConfig, shutdownTimeout time.Duration) error {
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	srv.BaseContext = func(net.Listener) context.Context { return requestCtx }
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if tls.enabled() {
+			slog.Info("server listening", "addr", srv.Addr, "tls", true)
+			serveErr <- srv.ListenAndServeTLS(tls.CertFile, tls.KeyFile)
+			return
+		}
+		slog.Info("server listening", "addr", srv.Addr, "tls", false)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down; draining requests", "timeout", shutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("requests still running after shutdown timeout; cancelling them", "timeout", shutdownTimeout.String())
+		cancelRequests()
+		err = srv.Close()
+	}
+	if listenErr := <-serveErr; !errors.Is(listenErr, http.ErrServerClosed) {
+		return listenErr
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// countsResponse is the body of /stats/counts.
+type countsResponse struct {
+	GroupBy string          `json:"groupBy"`
+	Start   string          `json:"start"`
+	End     string          `json:"end"`
+	Counts  []SightingCount `json:"counts"`
+}
+
+// getStatsCounts serves observation counts and summed individualCount for
+// the sightings between start and end (inclusive, in any form parseDate
+// accepts), grouped by day, week, month, state or county. The field
+// filters of the range endpoint apply. Groups are ordered by key; days
+// without data simply contribute nothing.
+func (s *server) getStatsCounts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	groupBy := strings.ToLower(query.Get("groupBy"))
+	if groupBy == "" {
+		groupBy = groupByDay
+	}
+	if _, ok := groupByColumns[groupBy]; !ok {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("groupBy must be one of day, week, month, state or county, got %q", query.Get("groupBy")))
+		return
+	}
+
+	now := time.Now()
+	startDate, err := parseDate(query.Get("start"), now)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidDate, fmt.Sprintf("Invalid start date: %v", err))
+		return
+	}
+	endDate, err := parseDate(query.Get("end"), now)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidDate, fmt.Sprintf("Invalid end date: %v", err))
+		return
+	}
+
+	filter, err := parseSightingFilter(r)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	start, end := startDate.Time(), endDate.Time()
+	filter.Start, filter.End = start, end
+	policy := policyFor(r.Context())
+	if err := policy.checkFilter(filter); err != nil {
+		writeProblem(w, r, http.StatusForbidden, codeForbidden, err.Error())
+		return
+	}
+	filter.CoordinateGrid = policy.CoordinateGrid
+
+	slog.DebugContext(r.Context(), "counts requested", "group_by", groupBy, "start", start.Format("2006-01-02"), "end", end.Format("2006-01-02"))
+	counts, err := s.store.Aggregate(r.Context(), filter, groupBy)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if counts == nil {
+		counts = []SightingCount{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(countsResponse{
+		GroupBy: groupBy,
+		Start:   start.Format("2006-01-02"),
+		End:     end.Format("2006-01-02"),
+		Counts:  counts,
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// memoryStore is a SightingStore over a fixed slice of records. It backs
+// offline development and tests.
+type memoryStore struct {
+	records []MyMonarchRecord
+}
+
+func newMemoryStore(records []MyMonarchRecord) *memoryStore {
+	sorted := append([]MyMonarchRecord(nil), records...)
+	sortRecords(sorted)
+	return &memoryStore{records: sorted}
+}
+
+// loadFixtures reads a JSON array of records in the same shape the API
+// serves. An empty path yields no records.
+func loadFixtures(path string) ([]MyMonarchRecord, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixtures: %w", err)
+	}
+	var records []MyMonarchRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse fixtures %s: %w", path, err)
+	}
+	return records, nil
+}
+
+func (s *memoryStore) Ping(context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func (s *memoryStore) LegacySightings(ctx context.Context) ([]MyMonarchRecord, error) {
+	result, err := s.Filter(ctx, legacyMonth)
+	return result.Records, err
+}
+
+func (s *memoryStore) ListByDate(ctx context.Context, day time.Time) ([]MyMonarchRecord, error) {
+	result, err := s.Filter(ctx, SightingFilter{Start: day, End: day})
+	return result.Records, err
+}
+
+func (s *memoryStore) ListByRange(ctx context.Context, start, end time.Time) (SightingResult, error) {
+	return s.Filter(ctx, SightingFilter{Start: start, End: end})
+}
+
+func (s *memoryStore) Filter(ctx context.Context, f SightingFilter) (SightingResult, error) {
+	return collectStream(ctx, f, s.Stream)
+}
+
+func (s *memoryStore) Stream(ctx context.Context, f SightingFilter, fn func(MyMonarchRecord) error) ([]time.Time, error) {
+	if err := f.validateRange(); err != nil {
+		return nil, err
+	}
+
+	present := make(map[string]bool)
+	for _, record := range s.records {
+		present[recordDate(record)] = true
+	}
+	missing := missingDays(f.Start, f.End, present)
+
+	sent := 0
+	for _, record := range s.records {
+		if f.Limit > 0 && sent >= f.Limit {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !f.matches(record) {
+			continue
+		}
+		if err := fn(record); err == errStopStream {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		sent++
+	}
+	return missing, nil
+}
+
+func (s *memoryStore) Tables(context.Context) ([]TableInfo, error) {
+	rowsByDay := make(map[string]int64)
+	for _, record := range s.records {
+		rowsByDay[recordDate(record)]++
+	}
+	return dailyTables(rowsByDay), nil
+}
+
+func (s *memoryStore) Aggregate(ctx context.Context, f SightingFilter, groupBy string) ([]SightingCount, error) {
+	if _, ok := groupByColumns[groupBy]; !ok {
+		return nil, fmt.Errorf("unsupported groupBy %q", groupBy)
+	}
+	result, err := s.Filter(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]SightingCount, 0, len(result.Records))
+	for _, record := range result.Records {
+		c := SightingCount{
+			Key:          formatGroupKey(groupBy, groupParts(groupBy, record)),
+			Observations: 1,
+		}
+		if record.IndividualCount != nil {
+			c.Individuals = *record.IndividualCount
+		}
+		counts = append(counts, c)
+	}
+	return mergeCounts(counts), nil
+}
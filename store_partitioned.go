@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Table layouts of a Postgres database. Daily keeps one table per day, as
+// the original schema does; partitioned keeps every day in the "sightings"
+// table written by the consolidate command. Auto picks partitioned once that
+// table holds rows.
+const (
+	layoutAuto        = "auto"
+	layoutDaily       = "daily"
+	layoutPartitioned = "partitioned"
+)
+
+// sightingColumn is a column of the partitioned sightings table.
+type sightingColumn struct {
+	name   string
+	pgType string
+}
+
+// sightingColumns lists the columns of the partitioned table with their
+// Postgres types, in monarchColumns order, as created by the
+// 0001_create_sightings migration. Rows copied from other tables are cast to
+// these types.
+var sightingColumns = []sightingColumn{
+	{"gbifID", "TEXT"},
+	{"datasetKey", "TEXT"},
+	{"publishingOrgKey", "TEXT"},
+	{"eventDate", "TEXT"},
+	{"eventDateParsed", "TIMESTAMP"},
+	{"year", "INTEGER"},
+	{"month", "INTEGER"},
+	{"day", "INTEGER"},
+	{"day_of_week", "INTEGER"},
+	{"week_of_year", "BIGINT"},
+	{"date_only", "DATE"},
+	{"scientificName", "TEXT"},
+	{"vernacularName", "TEXT"},
+	{"taxonKey", "BIGINT"},
+	{"kingdom", "TEXT"},
+	{"phylum", "TEXT"},
+	{"class", "TEXT"},
+	{"order", "TEXT"},
+	{"family", "TEXT"},
+	{"genus", "TEXT"},
+	{"species", "TEXT"},
+	{"decimalLatitude", "DOUBLE PRECISION"},
+	{"decimalLongitude", "DOUBLE PRECISION"},
+	{"coordinateUncertaintyInMeters", "DOUBLE PRECISION"},
+	{"countryCode", "TEXT"},
+	{"stateProvince", "TEXT"},
+	{"individualCount", "BIGINT"},
+	{"basisOfRecord", "TEXT"},
+	{"recordedBy", "TEXT"},
+	{"occurrenceID", "TEXT"},
+	{"collectionCode", "TEXT"},
+	{"catalogNumber", "TEXT"},
+	{"county", "TEXT"},
+	{"cityOrTown", "TEXT"},
+	{"time_only", "TIME"},
+}
+
+// trackingColumns are the columns the 0003_track_records migration adds to
+// the partitioned table, and imports add to the daily tables they write, to
+// follow records across repeated imports.
+var trackingColumns = []sightingColumn{
+	{"record_key", "TEXT"},
+	{"first_seen_at", "TIMESTAMPTZ"},
+	{"last_seen_at", "TIMESTAMPTZ"},
+	{"missing_since", "TIMESTAMPTZ"},
+}
+
+// recordKeyExpr computes the key identifying a record across GBIF
+// downloads: its gbifID, or else its datasetKey and occurrenceID. Rows with
+// neither get no key.
+const recordKeyExpr = `COALESCE('gbif:' || NULLIF(CAST("gbifID" AS TEXT), ''), ` +
+	`'occ:' || NULLIF(CAST("datasetKey" AS TEXT), '') || ':' || NULLIF(CAST("occurrenceID" AS TEXT), ''))`
+
+// partitionName returns the partition holding the month of day, e.g.
+// sightings_y2025m06.
+func partitionName(day calendarDate) string {
+	return fmt.Sprintf("sightings_y%04dm%02d", day.Year, int(day.Month))
+}
+
+// ensurePartitions creates the monthly partitions covering first to last.
+func ensurePartitions(ctx context.Context, tx *sql.Tx, first, last calendarDate) error {
+	month := dateOf(time.Date(first.Year, first.Month, 1, 0, 0, 0, 0, time.UTC))
+	for !last.Time().Before(month.Time()) {
+		next := dateOf(month.Time().AddDate(0, 1, 0))
+		name := partitionName(month)
+		query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" PARTITION OF "sightings" FOR VALUES FROM ('%s') TO ('%s')`, name, month, next)
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return newStoreError(stageQuery, name, err)
+		}
+		month = next
+	}
+	return nil
+}
+
+// partitionedStore is the SightingStore reading the partitioned "sightings"
+// table. The legacy monthly table is still read on its own.
+type partitionedStore struct {
+	unifiedTable
+	legacyTable string
+	// daily, when set, serves the days the partitioned table holds no rows
+	// for from their daily tables, so a partly consolidated database reads
+	// every day it has.
+	daily *postgresStore
+}
+
+func newPartitionedStore(db *sql.DB, legacyTable string, daily *postgresStore) *partitionedStore {
+	return &partitionedStore{
+		unifiedTable: unifiedTable{db: db, placeholder: pgPlaceholder, dateExpr: dateColumn},
+		legacyTable:  legacyTable,
+		daily:        daily,
+	}
+}
+
+// dayRun is a stretch of consecutive days that are all either in the
+// partitioned table or not.
+type dayRun struct {
+	start, end  time.Time
+	partitioned bool
+}
+
+// dayRuns splits the range of f into runs of days the partitioned table
+// holds rows for and runs of days it does not. Without a daily fallback the
+// whole range is one partitioned run.
+func (s *partitionedStore) dayRuns(ctx context.Context, f SightingFilter) ([]dayRun, error) {
+	if s.daily == nil {
+		return []dayRun{{start: f.Start, end: f.End, partitioned: true}}, nil
+	}
+	present, err := s.presentDays(ctx, f.Start, f.End)
+	if err != nil {
+		return nil, err
+	}
+	var runs []dayRun
+	for _, day := range daysBetween(f.Start, f.End) {
+		in := present[day.Format("2006-01-02")]
+		if n := len(runs); n > 0 && runs[n-1].partitioned == in {
+			runs[n-1].end = day
+			continue
+		}
+		runs = append(runs, dayRun{start: day, end: day, partitioned: in})
+	}
+	return runs, nil
+}
+
+func (s *partitionedStore) ListByDate(ctx context.Context, day time.Time) ([]MyMonarchRecord, error) {
+	if s.daily != nil {
+		present, err := s.presentDays(ctx, day, day)
+		if err != nil {
+			return nil, err
+		}
+		if !present[day.Format("2006-01-02")] {
+			return s.daily.ListByDate(ctx, day)
+		}
+	}
+	return s.unifiedTable.ListByDate(ctx, day)
+}
+
+func (s *partitionedStore) ListByRange(ctx context.Context, start, end time.Time) (SightingResult, error) {
+	return s.Filter(ctx, SightingFilter{Start: start, End: end})
+}
+
+func (s *partitionedStore) Filter(ctx context.Context, f SightingFilter) (SightingResult, error) {
+	return collectStream(ctx, f, s.Stream)
+}
+
+// Stream reads each run of days from where it lives, in order, so the
+// cursor order holds across the two layouts. Days in neither are missing.
+func (s *partitionedStore) Stream(ctx context.Context, f SightingFilter, fn func(MyMonarchRecord) error) ([]time.Time, error) {
+	if s.daily == nil {
+		return s.unifiedTable.Stream(ctx, f, fn)
+	}
+	if err := f.validateRange(); err != nil {
+		return nil, err
+	}
+	runs, err := s.dayRuns(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []time.Time
+	sent, stopped := 0, false
+	send := func(record MyMonarchRecord) error {
+		err := fn(record)
+		if err == errStopStream {
+			stopped = true
+		}
+		if err != nil {
+			return err
+		}
+		sent++
+		return nil
+	}
+	for _, run := range runs {
+		runFilter := f
+		runFilter.Start, runFilter.End = run.start, run.end
+		if f.Limit > 0 {
+			runFilter.Limit = f.Limit - sent
+		}
+		if run.partitioned {
+			err = s.streamRecords(ctx, runFilter, send)
+		} else {
+			var absent []time.Time
+			absent, err = s.daily.Stream(ctx, runFilter, send)
+			missing = append(missing, absent...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if stopped || (f.Limit > 0 && sent >= f.Limit) {
+			break
+		}
+	}
+	return missing, nil
+}
+
+func (s *partitionedStore) Aggregate(ctx context.Context, f SightingFilter, groupBy string) ([]SightingCount, error) {
+	if s.daily == nil {
+		return s.unifiedTable.Aggregate(ctx, f, groupBy)
+	}
+	if err := f.validateRange(); err != nil {
+		return nil, err
+	}
+	runs, err := s.dayRuns(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []SightingCount
+	for _, run := range runs {
+		runFilter := f
+		runFilter.Start, runFilter.End = run.start, run.end
+		var counts []SightingCount
+		if run.partitioned {
+			counts, err = s.unifiedTable.Aggregate(ctx, runFilter, groupBy)
+		} else {
+			counts, err = s.daily.Aggregate(ctx, runFilter, groupBy)
+		}
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, counts...)
+	}
+	return mergeCounts(all), nil
+}
+
+func (s *partitionedStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *partitionedStore) LegacySightings(ctx context.Context) ([]MyMonarchRecord, error) {
+	return queryLegacyTable(ctx, s.db, s.legacyTable)
+}
+
+// Tables reports one daily entry per day in the partitioned table, plus the
+// legacy table when it still exists. With a daily fallback the daily tables
+// of the days the partitioned table lacks are reported too.
+func (s *partitionedStore) Tables(ctx context.Context) ([]TableInfo, error) {
+	tables, err := s.unifiedTable.Tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.daily != nil {
+		present := make(map[string]bool, len(tables))
+		for _, t := range tables {
+			present[t.Date] = true
+		}
+		daily, err := s.daily.Tables(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// The daily store already reports the legacy table.
+		for _, t := range daily {
+			if t.Kind != tableKindDaily || !present[t.Date] {
+				tables = append(tables, t)
+			}
+		}
+		return tables, nil
+	}
+	legacy, err := describeTable(ctx, s.db, s.legacyTable)
+	if isUndefinedTable(err) {
+		return tables, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	legacy.Kind = tableKindLegacy
+	return append(tables, legacy), nil
+}
+
+// resolveLayout turns the configured layout into daily or partitioned. Auto
+// chooses partitioned when the partitioned table exists and holds rows; the
+// server then still reads the days it lacks from their daily tables.
+func resolveLayout(ctx context.Context, db *sql.DB, layout string) (string, error) {
+	if layout != layoutAuto {
+		return layout, nil
+	}
+
+	var partitioned bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_class WHERE oid = to_regclass('sightings') AND relkind = 'p')`).Scan(&partitioned)
+	if err != nil {
+		return "", newStoreError(stageQuery, "pg_class", err)
+	}
+	if !partitioned {
+		return layoutDaily, nil
+	}
+
+	var hasRows bool
+	if err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM "sightings")`).Scan(&hasRows); err != nil {
+		return "", newStoreError(stageQuery, "sightings", err)
+	}
+	if !hasRows {
+		slog.InfoContext(ctx, "partitioned sightings table is empty; reading daily tables")
+		return layoutDaily, nil
+	}
+	return layoutPartitioned, nil
+}
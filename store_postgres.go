@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// defaultLegacyTable is the monthly table served by /monarchsjune2025. It
+// only carries the location and time columns.
+const defaultLegacyTable = "2025_M06_JUN_2025_butterflies_CT"
+
+// postgresStore is the SightingStore backed by the shared Postgres pool. Each
+// calendar day lives in its own table named by naming.
+type postgresStore struct {
+	db *sql.DB
+
+	// concurrency bounds how many daily tables a range query reads at once.
+	concurrency int
+	naming      tableNaming
+	legacyTable string
+}
+
+func newPostgresStore(db *sql.DB, concurrency int, naming tableNaming, legacyTable string) *postgresStore {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &postgresStore{db: db, concurrency: concurrency, naming: naming, legacyTable: legacyTable}
+}
+
+func (s *postgresStore) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return &storeError{Stage: stageConnect, Err: err}
+	}
+	return nil
+}
+
+func (s *postgresStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *postgresStore) LegacySightings(ctx context.Context) ([]MyMonarchRecord, error) {
+	return queryLegacyTable(ctx, s.db, s.legacyTable)
+}
+
+// queryLegacyTable reads the location and time columns of the legacy
+// monthly table. Both Postgres layouts serve it the same way.
+func queryLegacyTable(ctx context.Context, db *sql.DB, table string) ([]MyMonarchRecord, error) {
+	query := fmt.Sprintf(`SELECT "date_only", "time_only", "cityOrTown", "county", "stateProvince" FROM "%s" ORDER BY "date_only"`, table)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, newStoreError(stageQuery, table, err)
+	}
+	defer rows.Close()
+
+	var monarchButterflies []MyMonarchRecord
+	for rows.Next() {
+		var monarchButterfly MyMonarchRecord
+		err := rows.Scan(&monarchButterfly.DateOnly, &monarchButterfly.TimeOnly, &monarchButterfly.CityOrTown, &monarchButterfly.County, &monarchButterfly.StateProvince)
+		if err != nil {
+			slog.WarnContext(ctx, "skipping unreadable legacy row", "table", table, "error", err)
+			continue
+		}
+		monarchButterflies = append(monarchButterflies, monarchButterfly)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, newStoreError(stageIterate, table, err)
+	}
+	return monarchButterflies, nil
+}
+
+func (s *postgresStore) ListByDate(ctx context.Context, day time.Time) ([]MyMonarchRecord, error) {
+	tableName := s.naming.name(dateOf(day))
+	slog.DebugContext(ctx, "using generated table name", "table", tableName)
+	return s.queryTable(ctx, tableName, SightingFilter{})
+}
+
+func (s *postgresStore) ListByRange(ctx context.Context, start, end time.Time) (SightingResult, error) {
+	return s.Filter(ctx, SightingFilter{Start: start, End: end})
+}
+
+// Filter queries the daily table of every day in the range concurrently and
+// merges the results. Days without a table are reported as missing. A limited
+// query reads the days in order instead, so it can stop once the page is full.
+func (s *postgresStore) Filter(ctx context.Context, f SightingFilter) (SightingResult, error) {
+	if err := f.validateRange(); err != nil {
+		return SightingResult{}, err
+	}
+	if f.Limit > 0 {
+		return collectStream(ctx, f, s.Stream)
+	}
+
+	days := daysBetween(f.Start, f.End)
+	perDay := make([][]MyMonarchRecord, len(days))
+	missing, err := s.fanOutDays(ctx, days, func(ctx context.Context, i int, tableName string) error {
+		records, err := s.queryTable(ctx, tableName, f)
+		perDay[i] = records
+		return err
+	})
+	if err != nil {
+		return SightingResult{}, err
+	}
+
+	var all []MyMonarchRecord
+	for _, records := range perDay {
+		all = append(all, records...)
+	}
+	sortRecords(all)
+	return SightingResult{Records: all, MissingDays: missing}, nil
+}
+
+// Stream reads the daily tables one day at a time, in order, starting at the
+// cursor's day. Missing days are only reported up to where the stream ended.
+func (s *postgresStore) Stream(ctx context.Context, f SightingFilter, fn func(MyMonarchRecord) error) ([]time.Time, error) {
+	if err := f.validateRange(); err != nil {
+		return nil, err
+	}
+
+	var missing []time.Time
+	sent := 0
+	for _, day := range daysBetween(f.Start, f.End) {
+		if f.After != nil && day.Format("2006-01-02") < f.After.Date {
+			continue
+		}
+
+		dayFilter := f
+		if f.Limit > 0 {
+			dayFilter.Limit = f.Limit - sent
+		}
+		stopped := false
+		err := s.streamTable(ctx, s.naming.name(dateOf(day)), dayFilter, func(record MyMonarchRecord) error {
+			if err := fn(record); err != nil {
+				return err
+			}
+			sent++
+			return nil
+		})
+		switch {
+		case err == errStopStream:
+			stopped = true
+		case isUndefinedTable(err):
+			missing = append(missing, day)
+		case err != nil:
+			return nil, err
+		}
+		if stopped || (f.Limit > 0 && sent >= f.Limit) {
+			break
+		}
+	}
+	return missing, nil
+}
+
+func (s *postgresStore) Aggregate(ctx context.Context, f SightingFilter, groupBy string) ([]SightingCount, error) {
+	if err := f.validateRange(); err != nil {
+		return nil, err
+	}
+	cols, ok := groupByColumns[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported groupBy %q", groupBy)
+	}
+
+	days := daysBetween(f.Start, f.End)
+	perDay := make([][]SightingCount, len(days))
+	_, err := s.fanOutDays(ctx, days, func(ctx context.Context, i int, tableName string) error {
+		counts, err := s.aggregateTable(ctx, tableName, f, cols, groupBy)
+		perDay[i] = counts
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var all []SightingCount
+	for _, counts := range perDay {
+		all = append(all, counts...)
+	}
+	return mergeCounts(all), nil
+}
+
+// fanOutDays calls query for the daily table of each day, running at most
+// s.concurrency queries at once. query receives the index of its day so it
+// can store its result without locking. Days whose table does not exist are
+// returned in order; any other error cancels the remaining queries.
+func (s *postgresStore) fanOutDays(ctx context.Context, days []time.Time, query func(ctx context.Context, i int, tableName string) error) ([]time.Time, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	absent := make([]bool, len(days))
+	sem := make(chan struct{}, s.concurrency)
+
+	for i, day := range days {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, tableName string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := query(ctx, i, tableName)
+			if isUndefinedTable(err) {
+				absent[i] = true
+				return
+			}
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}(i, s.naming.name(dateOf(day)))
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var missing []time.Time
+	for i, day := range days {
+		if absent[i] {
+			missing = append(missing, day)
+		}
+	}
+	return missing, nil
+}
+
+// queryTable selects the rows of one daily table that match f.
+func (s *postgresStore) queryTable(ctx context.Context, tableName string, f SightingFilter) ([]MyMonarchRecord, error) {
+	var monarchButterflies []MyMonarchRecord
+	err := s.streamTable(ctx, tableName, f, func(record MyMonarchRecord) error {
+		monarchButterflies = append(monarchButterflies, record)
+		return nil
+	})
+	return monarchButterflies, err
+}
+
+// streamTable selects the rows of one daily table that match f, in cursor
+// order and at most f.Limit of them, and hands each to fn as it is scanned.
+func (s *postgresStore) streamTable(ctx context.Context, tableName string, f SightingFilter, fn func(MyMonarchRecord) error) error {
+	where, args := f.whereClause(pgPlaceholder, noDateBounds)
+	query := fmt.Sprintf(`SELECT %s FROM "%s"%s ORDER BY %s`, monarchColumns, tableName, where, orderByKeys)
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return newStoreError(stageQuery, tableName, err)
+	}
+	defer rows.Close()
+
+	scanned := 0
+	defer func() { dbRowsScanned.WithLabelValues("select").Observe(float64(scanned)) }()
+	for rows.Next() {
+		record, err := scanMonarchRecord(rows)
+		if err != nil {
+			return newStoreError(stageScan, tableName, err)
+		}
+		scanned++
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return newStoreError(stageIterate, tableName, err)
+	}
+	return nil
+}
+
+// aggregateTable runs the GROUP BY for one daily table.
+func (s *postgresStore) aggregateTable(ctx context.Context, tableName string, f SightingFilter, cols []string, groupBy string) ([]SightingCount, error) {
+	where, args := f.whereClause(pgPlaceholder, noDateBounds)
+	return runAggregate(ctx, s.db, fmt.Sprintf(`"%s"`, tableName), where, args, cols, groupBy)
+}
+
+// runAggregate executes a grouped count over source and turns each group into
+// a SightingCount. It is shared by the SQL-backed stores.
+func runAggregate(ctx context.Context, db *sql.DB, source, where string, args []any, cols []string, groupBy string) ([]SightingCount, error) {
+	groupCols := strings.Join(cols, ", ")
+	query := fmt.Sprintf(`SELECT %s, COUNT(*), COALESCE(SUM("individualCount"), 0) FROM %s%s GROUP BY %s`, groupCols, source, where, groupCols)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, newStoreError(stageQuery, source, err)
+	}
+	defer rows.Close()
+
+	var counts []SightingCount
+	for rows.Next() {
+		parts := make([]sql.NullString, len(cols))
+		dest := make([]any, 0, len(cols)+2)
+		for i := range parts {
+			dest = append(dest, &parts[i])
+		}
+		var c SightingCount
+		dest = append(dest, &c.Observations, &c.Individuals)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, newStoreError(stageScan, source, err)
+		}
+
+		values := make([]string, len(parts))
+		for i, p := range parts {
+			values[i] = p.String
+		}
+		c.Key = formatGroupKey(groupBy, values)
+		counts = append(counts, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, newStoreError(stageIterate, source, err)
+	}
+	dbRowsScanned.WithLabelValues("aggregate").Observe(float64(len(counts)))
+	return mergeCounts(counts), nil
+}
+
+// Tables introspects information_schema for every table in the current
+// schema with a date_only column. Tables whose names follow the daily
+// naming scheme are reported as daily; the rest (such as the legacy table)
+// as legacy. Row counts and date coverage are read from each table. The
+// partitioned sightings table and its partitions are left out; they belong
+// to the partitioned layout.
+func (s *postgresStore) Tables(ctx context.Context) ([]TableInfo, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT table_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND column_name = 'date_only'
+		AND table_name NOT IN (SELECT relname FROM pg_class WHERE relispartition OR relkind = 'p')
+		ORDER BY table_name`)
+	if err != nil {
+		return nil, newStoreError(stageQuery, "information_schema.columns", err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, newStoreError(stageScan, "information_schema.columns", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, newStoreError(stageIterate, "information_schema.columns", err)
+	}
+
+	tables := make([]TableInfo, 0, len(names))
+	for _, name := range names {
+		info, err := describeTable(ctx, s.db, name)
+		if err != nil {
+			return nil, err
+		}
+		info.Kind = tableKindLegacy
+		if day, ok := s.naming.parse(name); ok {
+			info.Kind = tableKindDaily
+			info.Date = day.Format("2006-01-02")
+		}
+		tables = append(tables, info)
+	}
+	return tables, nil
+}
+
+// describeTable reads the row count and date coverage of a table with a
+// date_only column. The caller fills in its kind.
+func describeTable(ctx context.Context, db *sql.DB, name string) (TableInfo, error) {
+	info := TableInfo{Name: name}
+	var first, last sql.NullString
+	query := fmt.Sprintf(`SELECT COUNT(*), MIN(CAST("date_only" AS TEXT)), MAX(CAST("date_only" AS TEXT)) FROM "%s"`, name)
+	if err := db.QueryRowContext(ctx, query).Scan(&info.RowCount, &first, &last); err != nil {
+		return TableInfo{}, newStoreError(stageQuery, name, err)
+	}
+	info.FirstDate = dateKey(first.String)
+	info.LastDate = dateKey(last.String)
+	return info, nil
+}
+
+func pgPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// isUndefinedTable reports whether err is Postgres complaining that the
+// queried table does not exist.
+func isUndefinedTable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "42P01"
+}
+
+// isConnectionError reports whether err means the database could not be
+// reached: a network error, a connection the pool gave up on, or a server
+// refusing connections because it is starting or shutting down.
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08 is connection exceptions; 57P01 to 57P03 are shutdowns
+		// and "cannot connect now".
+		return pqErr.Code.Class() == "08" || pqErr.Code == "57P01" || pqErr.Code == "57P02" || pqErr.Code == "57P03"
+	}
+	return strings.Contains(err.Error(), "connection refused")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteStore is an embedded SightingStore that keeps every day in one table.
+type sqliteStore struct {
+	unifiedTable
+}
+
+// openSQLiteDB opens (or creates) the database at path.
+func openSQLiteDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+	// A single connection keeps ":memory:" databases shared across queries.
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// openSQLiteStore opens the database at path, brings its schema up to date
+// and seeds it from the fixtures file when the table is empty. The database
+// belongs to this process, so pending migrations are applied rather than
+// refused as they are for Postgres.
+func openSQLiteStore(path, fixtures string) (*sqliteStore, error) {
+	db, err := openSQLiteDB(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := newMigrator(db, "sqlite")
+	if err == nil {
+		err = m.Up(context.Background(), m.latest())
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate sqlite schema: %w", err)
+	}
+	s := &sqliteStore{unifiedTable{db: db, placeholder: sqlitePlaceholder, dateExpr: textDateColumn}}
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM "sightings"`).Scan(&n); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to count sqlite rows: %w", err)
+	}
+	if n == 0 && fixtures != "" {
+		records, err := loadFixtures(fixtures)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		if err := s.insert(context.Background(), records); err != nil {
+			db.Close()
+			return nil, err
+		}
+		slog.Info("seeded sqlite store", "records", len(records))
+	}
+	return s, nil
+}
+
+// insert adds records to the sightings table in one transaction.
+func (s *sqliteStore) insert(ctx context.Context, records []MyMonarchRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(recordValues(MyMonarchRecord{}))), ", ")
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(`INSERT INTO "sightings" (%s) VALUES (%s)`, monarchColumns, placeholders))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, record := range records {
+		if _, err := stmt.ExecContext(ctx, recordValues(record)...); err != nil {
+			return fmt.Errorf("failed to insert record %s: %w", deref(record.GBIFID), err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqliteStore) LegacySightings(ctx context.Context) ([]MyMonarchRecord, error) {
+	result, err := s.Filter(ctx, legacyMonth)
+	return result.Records, err
+}
+
+func sqlitePlaceholder(int) string {
+	return "?"
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fixtureStart and fixtureEnd are the first and last day of
+// fixtures/sightings.json.
+var (
+	fixtureStart = time.Date(2025, 6, 19, 0, 0, 0, 0, time.UTC)
+	fixtureEnd   = time.Date(2025, 6, 23, 0, 0, 0, 0, time.UTC)
+)
+
+// testStores returns the offline stores, each holding records, so a test can
+// check that they behave alike.
+func testStores(t *testing.T, records []MyMonarchRecord) map[string]SightingStore {
+	t.Helper()
+	sqlite, err := openSQLiteStore(":memory:", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	if err := sqlite.insert(context.Background(), records); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]SightingStore{
+		"memory": newMemoryStore(records),
+		"sqlite": sqlite,
+	}
+}
+
+// fixtureRecords returns the records of fixtures/sightings.json.
+func fixtureRecords(t *testing.T) []MyMonarchRecord {
+	t.Helper()
+	records, err := loadFixtures("fixtures/sightings.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// ptr returns a pointer to v, for building records.
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// getRange serves GET /monarchbutterlies/range?query from srv.
+func getRange(srv *server, query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	srv.getRangeScan(rec, httptest.NewRequest("GET", "/monarchbutterlies/range?"+query, nil))
+	return rec
+}
+
+// sighting returns a record on 2025-06-21 at lat, lon.
+func sighting(id string, lat, lon float64) MyMonarchRecord {
+	return MyMonarchRecord{
+		GBIFID:           ptr(id),
+		DateOnly:         ptr("2025-06-21"),
+		TimeOnly:         ptr("12:00:00"),
+		DecimalLatitude:  ptr(lat),
+		DecimalLongitude: ptr(lon),
+		RecordedBy:       ptr("Maria Lopez"),
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// unifiedTable implements the sightings queries against a single
+// "sightings" table holding every day, the layout of the sqlite store and
+// of the partitioned Postgres store.
+type unifiedTable struct {
+	db          *sql.DB
+	placeholder func(int) string
+	// dateExpr is how date_only is compared with YYYY-MM-DD bounds; see
+	// whereClause.
+	dateExpr string
+}
+
+func (s *unifiedTable) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return &storeError{Stage: stageConnect, Err: err}
+	}
+	return nil
+}
+
+func (s *unifiedTable) ListByDate(ctx context.Context, day time.Time) ([]MyMonarchRecord, error) {
+	result, err := s.Filter(ctx, SightingFilter{Start: day, End: day})
+	return result.Records, err
+}
+
+func (s *unifiedTable) ListByRange(ctx context.Context, start, end time.Time) (SightingResult, error) {
+	return s.Filter(ctx, SightingFilter{Start: start, End: end})
+}
+
+func (s *unifiedTable) Filter(ctx context.Context, f SightingFilter) (SightingResult, error) {
+	return collectStream(ctx, f, s.Stream)
+}
+
+func (s *unifiedTable) Stream(ctx context.Context, f SightingFilter, fn func(MyMonarchRecord) error) ([]time.Time, error) {
+	if err := f.validateRange(); err != nil {
+		return nil, err
+	}
+
+	// Read the present days first: the sqlite store holds a single
+	// connection, which the row stream below keeps busy until it is closed.
+	present, err := s.presentDays(ctx, f.Start, f.End)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.streamRecords(ctx, f, fn); err != nil {
+		return nil, err
+	}
+	return missingDays(f.Start, f.End, present), nil
+}
+
+// presentDays returns the days between start and end that hold any rows.
+func (s *unifiedTable) presentDays(ctx context.Context, start, end time.Time) (map[string]bool, error) {
+	query := fmt.Sprintf(`SELECT DISTINCT "date_only" FROM "sightings" WHERE %[1]s >= %[2]s AND %[1]s <= %[3]s`,
+		s.dateExpr, s.placeholder(1), s.placeholder(2))
+	rows, err := s.db.QueryContext(ctx, query, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return nil, newStoreError(stageQuery, "sightings", err)
+	}
+	defer rows.Close()
+
+	present := make(map[string]bool)
+	for rows.Next() {
+		var day string
+		if err := rows.Scan(&day); err != nil {
+			return nil, newStoreError(stageScan, "sightings", err)
+		}
+		present[dateKey(day)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newStoreError(stageIterate, "sightings", err)
+	}
+	return present, nil
+}
+
+// streamRecords selects the rows matching f in cursor order and hands each
+// to fn as it is scanned.
+func (s *unifiedTable) streamRecords(ctx context.Context, f SightingFilter, fn func(MyMonarchRecord) error) error {
+	where, args := f.whereClause(s.placeholder, s.dateExpr)
+	query := fmt.Sprintf(`SELECT %s FROM "sightings"%s ORDER BY %s`, monarchColumns, where, orderByKeys)
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return newStoreError(stageQuery, "sightings", err)
+	}
+	defer rows.Close()
+
+	scanned := 0
+	defer func() { dbRowsScanned.WithLabelValues("select").Observe(float64(scanned)) }()
+	for rows.Next() {
+		record, err := scanMonarchRecord(rows)
+		if err != nil {
+			return newStoreError(stageScan, "sightings", err)
+		}
+		scanned++
+		if err := fn(record); err == errStopStream {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return newStoreError(stageIterate, "sightings", err)
+	}
+	return nil
+}
+
+// Tables reports one daily entry per day that has rows.
+func (s *unifiedTable) Tables(ctx context.Context) ([]TableInfo, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT "date_only", COUNT(*) FROM "sightings" GROUP BY "date_only"`)
+	if err != nil {
+		return nil, newStoreError(stageQuery, "sightings", err)
+	}
+	defer rows.Close()
+
+	rowsByDay := make(map[string]int64)
+	for rows.Next() {
+		var day sql.NullString
+		var n int64
+		if err := rows.Scan(&day, &n); err != nil {
+			return nil, newStoreError(stageScan, "sightings", err)
+		}
+		rowsByDay[dateKey(day.String)] += n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newStoreError(stageIterate, "sightings", err)
+	}
+	return dailyTables(rowsByDay), nil
+}
+
+func (s *unifiedTable) Aggregate(ctx context.Context, f SightingFilter, groupBy string) ([]SightingCount, error) {
+	if err := f.validateRange(); err != nil {
+		return nil, err
+	}
+	cols, ok := groupByColumns[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported groupBy %q", groupBy)
+	}
+
+	where, args := f.whereClause(s.placeholder, s.dateExpr)
+	return runAggregate(ctx, s.db, `"sightings"`, where, args, cols, groupBy)
+}